@@ -36,10 +36,10 @@ print_status "Checking SemWare environment variables..."
 if [ -z "$SEMWARE_API_KEY" ]; then
     print_warning "SEMWARE_API_KEY is not set"
     print_warning "To enable related notes feature, set it in your fish shell:"
-    echo "  set -x SEMWARE_API_KEY \"your-secure-api-key-here\""
+    echo "  set -x SEMWARE_API_KEY \"<key configured in SemWare>\""
     echo ""
     print_warning "You can also set it temporarily for this session:"
-    echo "  set -x SEMWARE_API_KEY \"your-secure-api-key-here\" && ./build.sh"
+    echo "  set -x SEMWARE_API_KEY \"<key configured in SemWare>\" && ./build.sh"
     echo ""
 else
     print_status "SEMWARE_API_KEY is set ✓"
@@ -13,6 +13,9 @@ type Note struct {
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Language is the language code set for the note, or empty to detect it
+	Language  string     `json:"language,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type Collection struct {
@@ -42,10 +45,15 @@ type Attachment struct {
 
 type DB struct {
 	*sql.DB
+	// indexTargets are the indexes that receive outbox jobs
+	indexTargets []string
 }
 
 func NewDB(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	// Background index workers write concurrently with requests, so wait for
+	// locks instead of failing, and take the write lock when a transaction
+	// begins so two transactions can never deadlock upgrading their locks
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -58,9 +66,15 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	if err := runMigrations(db); err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: db, indexTargets: AllIndexTargets}, nil
 }
 
+// createTables creates the base schema that predates the migration system.
+// Every later schema change is a numbered migration in migrations.go.
 func createTables(db *sql.DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS notes (
@@ -74,10 +88,7 @@ func createTables(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS collections (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL UNIQUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		is_auto BOOLEAN DEFAULT FALSE,
-		description TEXT,
-		threshold REAL DEFAULT 0.3
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
 	CREATE TABLE IF NOT EXISTS note_collections (
@@ -106,59 +117,7 @@ func createTables(db *sql.DB) error {
 	`
 
 	_, err := db.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	// Migrate existing collections table to add auto-collection columns
-	return migrateCollectionsTable(db)
-}
-
-func migrateCollectionsTable(db *sql.DB) error {
-	// Check if is_auto column exists
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('collections') WHERE name = 'is_auto'").Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	// If column doesn't exist, add it
-	if count == 0 {
-		_, err = db.Exec("ALTER TABLE collections ADD COLUMN is_auto BOOLEAN DEFAULT FALSE")
-		if err != nil {
-			return err
-		}
-	}
-
-	// Check if description column exists
-	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('collections') WHERE name = 'description'").Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	// If column doesn't exist, add it
-	if count == 0 {
-		_, err = db.Exec("ALTER TABLE collections ADD COLUMN description TEXT")
-		if err != nil {
-			return err
-		}
-	}
-
-	// Check if threshold column exists
-	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('collections') WHERE name = 'threshold'").Scan(&count)
-	if err != nil {
-		return err
-	}
-
-	// If column doesn't exist, add it
-	if count == 0 {
-		_, err = db.Exec("ALTER TABLE collections ADD COLUMN threshold REAL DEFAULT 0.3")
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return err
 }
 
 // Collection methods
@@ -267,30 +226,120 @@ func (db *DB) GetAllCollections() ([]*Collection, error) {
 }
 
 func (db *DB) DeleteCollection(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	members, err := collectionMembers(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM note_collections WHERE collection_id = ?`, id); err != nil {
+		return err
+	}
+
 	query := `DELETE FROM collections WHERE id = ?`
-	_, err := db.Exec(query, id)
-	return err
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+
+	if err := reindexCollectionMembers(tx, members); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Note-Collection relationship methods
 func (db *DB) AddNoteToCollection(noteID, collectionID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	INSERT OR IGNORE INTO note_collections (note_id, collection_id)
 	VALUES (?, ?)
 	`
 
-	_, err := db.Exec(query, noteID, collectionID)
-	return err
+	result, err := tx.Exec(query, noteID, collectionID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected > 0 {
+		if err := reindexCollectionMembers(tx, []int64{noteID}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (db *DB) RemoveNoteFromCollection(noteID, collectionID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	DELETE FROM note_collections
 	WHERE note_id = ? AND collection_id = ?
 	`
 
-	_, err := db.Exec(query, noteID, collectionID)
-	return err
+	result, err := tx.Exec(query, noteID, collectionID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected > 0 {
+		if err := reindexCollectionMembers(tx, []int64{noteID}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// collectionMembers returns the IDs of the notes in a collection
+func collectionMembers(tx *sql.Tx, collectionID int64) ([]int64, error) {
+	rows, err := tx.Query(`SELECT note_id FROM note_collections WHERE collection_id = ?`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// reindexCollectionMembers queues BM25 upserts for notes whose collections
+// changed, since the BM25 index stores collection names for facets
+func reindexCollectionMembers(tx *sql.Tx, noteIDs []int64) error {
+	for _, id := range noteIDs {
+		if err := enqueueIndexJob(tx, id, IndexTargetBM25, IndexOpUpsert); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // SyncAutoCollection removes all notes from an auto-collection and re-adds them based on semantic similarity
@@ -302,8 +351,18 @@ func (db *DB) SyncAutoCollection(collectionID int64, noteIDs []int64) error {
 	}
 	defer tx.Rollback()
 
-	// Remove all existing notes from the collection
-	removeQuery := `DELETE FROM note_collections WHERE collection_id = ?`
+	previous, err := collectionMembers(tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	// Remove all existing notes from the collection, keeping memberships of
+	// trashed notes so they come back on restore
+	removeQuery := `
+	DELETE FROM note_collections
+	WHERE collection_id = ?
+	AND note_id NOT IN (SELECT id FROM notes WHERE deleted_at IS NOT NULL)
+	`
 	_, err = tx.Exec(removeQuery, collectionID)
 	if err != nil {
 		return err
@@ -320,6 +379,24 @@ func (db *DB) SyncAutoCollection(collectionID int64, noteIDs []int64) error {
 		}
 	}
 
+	// Reindex the notes that joined or left the collection
+	changed := make(map[int64]bool)
+	for _, id := range previous {
+		changed[id] = true
+	}
+	for _, id := range noteIDs {
+		changed[id] = !changed[id]
+	}
+	var reindex []int64
+	for id, c := range changed {
+		if c {
+			reindex = append(reindex, id)
+		}
+	}
+	if err := reindexCollectionMembers(tx, reindex); err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	return tx.Commit()
 }
@@ -359,12 +436,40 @@ func (db *DB) GetNoteCollections(noteID int64) ([]*Collection, error) {
 	return collections, nil
 }
 
+// GetAllNoteCollections returns every collection membership of live notes
+func (db *DB) GetAllNoteCollections() ([]*NoteCollection, error) {
+	query := `
+	SELECT nc.note_id, nc.collection_id
+	FROM note_collections nc
+	JOIN notes n ON n.id = nc.note_id
+	WHERE n.deleted_at IS NULL
+	ORDER BY nc.collection_id, nc.note_id
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []*NoteCollection
+	for rows.Next() {
+		membership := &NoteCollection{}
+		if err := rows.Scan(&membership.NoteID, &membership.CollectionID); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, nil
+}
+
 func (db *DB) GetNotesByCollection(collectionID int64) ([]*Note, error) {
 	query := `
-	SELECT n.id, n.title, n.content, n.created_at, n.updated_at
+	SELECT n.id, n.title, n.content, n.created_at, n.updated_at, COALESCE(n.language, '')
 	FROM notes n
 	JOIN note_collections nc ON n.id = nc.note_id
-	WHERE nc.collection_id = ?
+	WHERE nc.collection_id = ? AND n.deleted_at IS NULL
 	ORDER BY n.updated_at DESC
 	`
 
@@ -383,6 +488,7 @@ func (db *DB) GetNotesByCollection(collectionID int64) ([]*Note, error) {
 			&note.Content,
 			&note.CreatedAt,
 			&note.UpdatedAt,
+			&note.Language,
 		)
 		if err != nil {
 			return nil, err
@@ -393,6 +499,36 @@ func (db *DB) GetNotesByCollection(collectionID int64) ([]*Note, error) {
 	return notes, nil
 }
 
+// GetCollectionNoteIDsByName returns the live notes in the collection with
+// the given name, compared case-insensitively
+func (db *DB) GetCollectionNoteIDsByName(name string) ([]int64, error) {
+	query := `
+	SELECT nc.note_id
+	FROM note_collections nc
+	JOIN collections c ON c.id = nc.collection_id
+	JOIN notes n ON n.id = nc.note_id
+	WHERE c.name = ? COLLATE NOCASE AND n.deleted_at IS NULL
+	ORDER BY nc.note_id
+	`
+
+	rows, err := db.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
 func (db *DB) GetOrCreateCollection(name string) (*Collection, error) {
 	// Try to get existing collection
 	collection, err := db.GetCollectionByName(name)
@@ -405,12 +541,18 @@ func (db *DB) GetOrCreateCollection(name string) (*Collection, error) {
 }
 
 func (db *DB) CreateNote(title, content string) (*Note, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
 	INSERT INTO notes (title, content, created_at, updated_at)
 	VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	result, err := db.Exec(query, title, content)
+	result, err := tx.Exec(query, title, content)
 	if err != nil {
 		return nil, err
 	}
@@ -420,14 +562,35 @@ func (db *DB) CreateNote(title, content string) (*Note, error) {
 		return nil, err
 	}
 
+	// Record the initial state so the first edit can be undone
+	if err := recordRevision(tx, id, false); err != nil {
+		return nil, err
+	}
+
+	if err := saveNoteLinks(tx, id, content); err != nil {
+		return nil, err
+	}
+
+	if err := syncNoteFTS(tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := db.enqueueIndexJobs(tx, id, IndexOpUpsert); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return db.GetNote(id)
 }
 
 func (db *DB) GetNote(id int64) (*Note, error) {
 	query := `
-	SELECT id, title, content, created_at, updated_at
+	SELECT id, title, content, created_at, updated_at, COALESCE(language, '')
 	FROM notes
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
 	note := &Note{}
@@ -437,6 +600,7 @@ func (db *DB) GetNote(id int64) (*Note, error) {
 		&note.Content,
 		&note.CreatedAt,
 		&note.UpdatedAt,
+		&note.Language,
 	)
 
 	if err != nil {
@@ -448,8 +612,9 @@ func (db *DB) GetNote(id int64) (*Note, error) {
 
 func (db *DB) GetAllNotes() ([]*Note, error) {
 	query := `
-	SELECT id, title, content, created_at, updated_at
+	SELECT id, title, content, created_at, updated_at, COALESCE(language, '')
 	FROM notes
+	WHERE deleted_at IS NULL
 	ORDER BY updated_at DESC
 	`
 
@@ -468,6 +633,7 @@ func (db *DB) GetAllNotes() ([]*Note, error) {
 			&note.Content,
 			&note.CreatedAt,
 			&note.UpdatedAt,
+			&note.Language,
 		)
 		if err != nil {
 			return nil, err
@@ -479,58 +645,119 @@ func (db *DB) GetAllNotes() ([]*Note, error) {
 }
 
 func (db *DB) UpdateNote(id int64, title, content string) (*Note, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Notes from before revisions were kept have none yet; keep their
+	// current state before it is overwritten
+	if err := recordFirstRevision(tx, id); err != nil {
+		return nil, err
+	}
+
 	query := `
 	UPDATE notes
 	SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	_, err := db.Exec(query, title, content, id)
+	result, err := tx.Exec(query, title, content, id)
 	if err != nil {
 		return nil, err
 	}
 
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	// Snapshot the new state, folding auto-save bursts into one revision
+	if err := recordRevision(tx, id, true); err != nil {
+		return nil, err
+	}
+
+	if err := saveNoteLinks(tx, id, content); err != nil {
+		return nil, err
+	}
+
+	if err := syncNoteFTS(tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := db.enqueueIndexJobs(tx, id, IndexOpUpsert); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return db.GetNote(id)
 }
 
+// DeleteNote moves a note to the trash. The row, its revisions and its
+// collection memberships are kept so the note can be restored later.
 func (db *DB) DeleteNote(id int64) error {
-	query := `DELETE FROM notes WHERE id = ?`
-	_, err := db.Exec(query, id)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE notes SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Trashed notes must not show up in search results
+	if err := syncNoteFTS(tx, id); err != nil {
+		return err
+	}
+
+	if err := db.enqueueIndexJobs(tx, id, IndexOpDelete); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (db *DB) SearchNotes(query string) ([]*Note, error) {
-	sqlQuery := `
-	SELECT id, title, content, created_at, updated_at
-	FROM notes
-	WHERE title LIKE ? OR content LIKE ?
-	ORDER BY updated_at DESC
-	`
+// SearchNotes finds live notes containing every word of query as a word
+// prefix, using the FTS index. It returns a page of up to limit matches
+// after the first offset, or all of them if limit is 0, and the number of
+// matches.
+func (db *DB) SearchNotes(query string, offset, limit int) ([]*FTSResult, int, error) {
+	match := FTSPrefixMatch(query)
+	if match == "" {
+		return []*FTSResult{}, 0, nil
+	}
 
-	searchTerm := "%" + query + "%"
-	rows, err := db.Query(sqlQuery, searchTerm, searchTerm)
+	results, err := db.SearchNotesFTS(match, offset, limit)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	defer rows.Close()
 
-	var notes []*Note
-	for rows.Next() {
-		note := &Note{}
-		err := rows.Scan(
-			&note.ID,
-			&note.Title,
-			&note.Content,
-			&note.CreatedAt,
-			&note.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		notes = append(notes, note)
+	// A page that is not full holds the last matches, so they need no count
+	if (limit == 0 || len(results) < limit) && (offset == 0 || len(results) > 0) {
+		return results, offset + len(results), nil
 	}
 
-	return notes, nil
+	total, err := db.CountNotesFTS(match)
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
 }
 
 func (db *DB) CreateAttachment(filename, originalName, mimeType, path, url string, size int64) (*Attachment, error) {
@@ -618,3 +845,67 @@ func (db *DB) GetAllAttachments() ([]*Attachment, error) {
 
 	return attachments, nil
 }
+
+// SetNoteLanguage sets the language a note is written in. An empty language
+// means it is detected from the text. Only the BM25 index analyzes text by
+// language, so only it is re-indexed.
+func (db *DB) SetNoteLanguage(id int64, language string) (*Note, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE notes SET language = ? WHERE id = ? AND deleted_at IS NULL`
+	result, err := tx.Exec(query, language, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err := enqueueIndexJob(tx, id, IndexTargetBM25, IndexOpUpsert); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return db.GetNote(id)
+}
+
+// GetCollectionNamesByNote returns the names of the collections of every live
+// note, keyed by note ID
+func (db *DB) GetCollectionNamesByNote() (map[int64][]string, error) {
+	query := `
+	SELECT nc.note_id, c.name
+	FROM note_collections nc
+	JOIN collections c ON c.id = nc.collection_id
+	JOIN notes n ON n.id = nc.note_id
+	WHERE n.deleted_at IS NULL
+	ORDER BY nc.note_id, c.name
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int64][]string)
+	for rows.Next() {
+		var noteID int64
+		var name string
+		if err := rows.Scan(&noteID, &name); err != nil {
+			return nil, err
+		}
+		names[noteID] = append(names[noteID], name)
+	}
+
+	return names, nil
+}
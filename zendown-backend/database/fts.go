@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"zendown/notetext"
+
+	"modernc.org/sqlite"
+)
+
+// The FTS table holds the readable text of the note rather than its HTML.
+// Migrations that fill it extract the text with this SQL function, which is
+// registered for every connection this process opens. Note writes keep the
+// table in sync from Go instead, see syncNoteFTS, so other tools can still
+// write to the database.
+func init() {
+	err := sqlite.RegisterDeterministicScalarFunction("note_text", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		content, _ := args[0].(string)
+		return notetext.Plain(content), nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to register note_text: %v", err))
+	}
+}
+
+// syncNoteFTS replaces the full-text row of a note with its current text, or
+// drops it if the note is gone or in the trash. Every note write calls it in
+// its transaction.
+func syncNoteFTS(tx *sql.Tx, noteID int64) error {
+	if _, err := tx.Exec(`DELETE FROM notes_fts WHERE rowid = ?`, noteID); err != nil {
+		return err
+	}
+
+	var title, content string
+	err := tx.QueryRow(`SELECT title, content FROM notes WHERE id = ? AND deleted_at IS NULL`, noteID).Scan(&title, &content)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO notes_fts (rowid, title, body) VALUES (?, ?, ?)`, noteID, title, notetext.Plain(content))
+	return err
+}
+
+// Snippet markers are control characters so the snippet can be HTML-escaped
+// before they become <mark> tags
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// FTSResult is a note matched by the SQLite full-text index. Score is the
+// negated bm25() rank, so higher is better, and Snippet an HTML-escaped
+// excerpt with matches wrapped in <mark>.
+type FTSResult struct {
+	*Note
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchNotesFTS runs an FTS5 match expression against live notes, best
+// matches first, skipping the first offset. Title matches weigh twice as much
+// as body matches. A limit of 0 returns every match.
+func (db *DB) SearchNotesFTS(match string, offset, limit int) ([]*FTSResult, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	query := `
+	SELECT n.id, n.title, n.content, n.created_at, n.updated_at, COALESCE(n.language, ''),
+		-bm25(notes_fts, 2.0, 1.0) AS score,
+		snippet(notes_fts, -1, ?, ?, '…', 24)
+	FROM notes_fts
+	JOIN notes n ON n.id = notes_fts.rowid
+	WHERE notes_fts MATCH ? AND n.deleted_at IS NULL
+	ORDER BY score DESC, n.id ASC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.Query(query, snippetStart, snippetEnd, match, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*FTSResult{}
+	for rows.Next() {
+		result := &FTSResult{Note: &Note{}}
+		err := rows.Scan(
+			&result.ID,
+			&result.Title,
+			&result.Content,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Language,
+			&result.Score,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.Snippet = markSnippet(result.Snippet)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// CountNotesFTS returns the number of live notes matching an FTS5 match
+// expression
+func (db *DB) CountNotesFTS(match string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM notes_fts
+	JOIN notes n ON n.id = notes_fts.rowid
+	WHERE notes_fts MATCH ? AND n.deleted_at IS NULL
+	`
+
+	var count int
+	err := db.QueryRow(query, match).Scan(&count)
+	return count, err
+}
+
+// MatchingNotesFTS returns the IDs of the notes matching an FTS5 expression
+func (db *DB) MatchingNotesFTS(match string) (map[int64]bool, error) {
+	query := `
+	SELECT n.id
+	FROM notes_fts
+	JOIN notes n ON n.id = notes_fts.rowid
+	WHERE notes_fts MATCH ? AND n.deleted_at IS NULL
+	`
+
+	rows, err := db.Query(query, match)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matching := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		matching[id] = true
+	}
+	return matching, rows.Err()
+}
+
+// markSnippet escapes a snippet and turns its markers into <mark> tags
+func markSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetEnd, "</mark>")
+}
+
+// FTSPrefixMatch turns free text into an FTS5 expression requiring every word
+// as a word prefix, so partially typed words still match. It returns an
+// empty string if the text has no words.
+func FTSPrefixMatch(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, FTSQuote(word)+"*")
+	}
+	return strings.Join(terms, " ")
+}
+
+// FTSQuote quotes text as an FTS5 string, which matches it as a phrase
+func FTSQuote(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
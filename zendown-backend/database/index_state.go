@@ -0,0 +1,95 @@
+package database
+
+import (
+	"time"
+)
+
+// MarkIndexed records that an index now holds the version of a note last
+// updated at updatedAt
+func (db *DB) MarkIndexed(noteID int64, target string, updatedAt time.Time) error {
+	_, err := db.Exec(`
+	INSERT INTO index_state (note_id, target, note_updated_at, indexed_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (note_id, target) DO UPDATE SET
+		note_updated_at = excluded.note_updated_at,
+		indexed_at = excluded.indexed_at
+	`, noteID, target, updatedAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// MarkUnindexed records that a note was removed from an index
+func (db *DB) MarkUnindexed(noteID int64, target string) error {
+	_, err := db.Exec(`DELETE FROM index_state WHERE note_id = ? AND target = ?`, noteID, target)
+	return err
+}
+
+// GetIndexState returns the updated_at of every note version an index is
+// known to hold, keyed by note ID
+func (db *DB) GetIndexState(target string) (map[int64]time.Time, error) {
+	rows, err := db.Query(`SELECT note_id, note_updated_at FROM index_state WHERE target = ?`, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[int64]time.Time)
+	for rows.Next() {
+		var noteID int64
+		var updatedAt time.Time
+		if err := rows.Scan(&noteID, &updatedAt); err != nil {
+			return nil, err
+		}
+		state[noteID] = updatedAt
+	}
+
+	return state, nil
+}
+
+// GetRecordedDeletedNoteIDs returns notes that are gone or in the trash but
+// that the sync state of any index, or an outbox job of target, still refers
+// to. An index that cannot be listed may still hold documents for them.
+func (db *DB) GetRecordedDeletedNoteIDs(target string) (map[int64]bool, error) {
+	rows, err := db.Query(`
+	SELECT note_id FROM index_state
+	WHERE note_id NOT IN (SELECT id FROM notes WHERE deleted_at IS NULL)
+	UNION
+	SELECT note_id FROM index_outbox
+	WHERE target = ? AND note_id NOT IN (SELECT id FROM notes WHERE deleted_at IS NULL)
+	`, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var noteID int64
+		if err := rows.Scan(&noteID); err != nil {
+			return nil, err
+		}
+		ids[noteID] = true
+	}
+
+	return ids, rows.Err()
+}
+
+// GetPendingIndexNoteIDs returns the notes that still have pending outbox
+// jobs for an index
+func (db *DB) GetPendingIndexNoteIDs(target string) (map[int64]bool, error) {
+	rows, err := db.Query(`SELECT DISTINCT note_id FROM index_outbox WHERE target = ? AND status = ?`, target, IndexJobPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var noteID int64
+		if err := rows.Scan(&noteID); err != nil {
+			return nil, err
+		}
+		ids[noteID] = true
+	}
+
+	return ids, nil
+}
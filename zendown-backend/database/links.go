@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+
+	"zendown/links"
+)
+
+// NoteLink is an explicit [[...]] reference from one note to another.
+// Title links are resolved when read, so creating or renaming a note
+// immediately changes which links point at it.
+type NoteLink struct {
+	SourceID    int64  `json:"source_id"`
+	SourceTitle string `json:"source_title"`
+	TargetID    *int64 `json:"target_id"`
+	TargetTitle string `json:"target_title"`
+	Alias       string `json:"alias,omitempty"`
+	Resolved    bool   `json:"resolved"`
+}
+
+// resolvedTargetSQL resolves a note_links row (aliased l) to a live note ID,
+// either through an explicit [[id|alias]] reference or by case-insensitive
+// title match. Unresolved links yield NULL.
+const resolvedTargetSQL = `
+	CASE WHEN l.target_id IS NOT NULL
+		THEN (SELECT t.id FROM notes t WHERE t.id = l.target_id AND t.deleted_at IS NULL)
+		ELSE (SELECT t.id FROM notes t WHERE t.title = l.target_title COLLATE NOCASE AND t.deleted_at IS NULL ORDER BY t.id LIMIT 1)
+	END`
+
+// saveNoteLinks re-parses the links of a note and replaces its stored links
+func saveNoteLinks(tx *sql.Tx, noteID int64, content string) error {
+	if _, err := tx.Exec(`DELETE FROM note_links WHERE source_id = ?`, noteID); err != nil {
+		return err
+	}
+
+	for position, ref := range links.Parse(content) {
+		var targetID sql.NullInt64
+		if id, ok := ref.NoteID(); ok {
+			targetID = sql.NullInt64{Int64: id, Valid: true}
+		}
+
+		_, err := tx.Exec(`
+		INSERT INTO note_links (source_id, target_id, target_title, alias, position)
+		VALUES (?, ?, ?, ?, ?)
+		`, noteID, targetID, ref.Target, ref.Alias, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) queryNoteLinks(where string, args ...interface{}) ([]*NoteLink, error) {
+	query := `
+	SELECT l.source_id, s.title, l.target_title, COALESCE(l.alias, ''),` + resolvedTargetSQL + ` AS resolved_id
+	FROM note_links l
+	JOIN notes s ON s.id = l.source_id AND s.deleted_at IS NULL
+	WHERE ` + where + `
+	ORDER BY l.source_id, l.position
+	`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*NoteLink
+	for rows.Next() {
+		link := &NoteLink{}
+		var resolvedID sql.NullInt64
+		err := rows.Scan(
+			&link.SourceID,
+			&link.SourceTitle,
+			&link.TargetTitle,
+			&link.Alias,
+			&resolvedID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if resolvedID.Valid {
+			id := resolvedID.Int64
+			link.TargetID = &id
+			link.Resolved = true
+		}
+		result = append(result, link)
+	}
+
+	return result, nil
+}
+
+// GetOutlinks returns the links written in a note, resolved or not
+func (db *DB) GetOutlinks(noteID int64) ([]*NoteLink, error) {
+	return db.queryNoteLinks(`l.source_id = ?`, noteID)
+}
+
+// GetBacklinks returns the links in other notes that resolve to noteID
+func (db *DB) GetBacklinks(noteID int64) ([]*NoteLink, error) {
+	return db.queryNoteLinks(`(`+resolvedTargetSQL+`) = ?`, noteID)
+}
+
+// GetUnresolvedLinks returns every link whose target does not exist
+func (db *DB) GetUnresolvedLinks() ([]*NoteLink, error) {
+	return db.queryNoteLinks(`(` + resolvedTargetSQL + `) IS NULL`)
+}
+
+// GetResolvedLinks returns every link in the vault that points at a live note
+func (db *DB) GetResolvedLinks() ([]*NoteLink, error) {
+	return db.queryNoteLinks(`(` + resolvedTargetSQL + `) IS NOT NULL`)
+}
+
+// RewriteLinksTo points the [[oldTitle]] links that resolved to noteID at
+// newTitle after the note was renamed. If another live note titled oldTitle
+// has a lower ID, the links resolved to that note and are left alone. Only
+// the link text changes, so the touched notes keep their updated_at and get
+// no revision or reindex.
+func (db *DB) RewriteLinksTo(noteID int64, oldTitle, newTitle string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var shadowed bool
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM notes
+		WHERE title = ? COLLATE NOCASE AND deleted_at IS NULL AND id < ?
+	)
+	`, oldTitle, noteID).Scan(&shadowed)
+	if err != nil {
+		return err
+	}
+	if shadowed {
+		return nil
+	}
+
+	rows, err := tx.Query(`
+	SELECT DISTINCT n.id, n.content
+	FROM notes n
+	JOIN note_links l ON l.source_id = n.id
+	WHERE l.target_id IS NULL AND l.target_title = ? COLLATE NOCASE AND n.deleted_at IS NULL
+	ORDER BY n.id
+	`, oldTitle)
+	if err != nil {
+		return err
+	}
+
+	type source struct {
+		id      int64
+		content string
+	}
+	var sources []source
+	for rows.Next() {
+		var s source
+		if err := rows.Scan(&s.id, &s.content); err != nil {
+			rows.Close()
+			return err
+		}
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sources {
+		content, ok := links.Rewrite(s.content, oldTitle, newTitle)
+		if !ok {
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE notes SET content = ? WHERE id = ?`, content, s.id); err != nil {
+			return err
+		}
+
+		if err := saveNoteLinks(tx, s.id, content); err != nil {
+			return err
+		}
+
+		if err := syncNoteFTS(tx, s.id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,517 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// migration is a single, numbered schema change. Versions must be unique and
+// strictly increasing; once released a migration must never be edited, only
+// followed by a new one.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// MigrationInfo describes a migration without its implementation
+type MigrationInfo struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+}
+
+// migrations is the ordered list of schema changes applied on top of the base
+// schema from createTables. Append new migrations at the end.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add is_auto column to collections",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "collections", "is_auto", "BOOLEAN DEFAULT FALSE")
+		},
+	},
+	{
+		version:     2,
+		description: "add description column to collections",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "collections", "description", "TEXT")
+		},
+	},
+	{
+		version:     3,
+		description: "add threshold column to collections",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "collections", "threshold", "REAL DEFAULT 0.3")
+		},
+	},
+	{
+		version:     4,
+		description: "create note_revisions table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS note_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				note_id INTEGER NOT NULL,
+				title TEXT NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_note_revisions_note_id ON note_revisions(note_id);
+			`)
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "add deleted_at column to notes for the trash bin",
+		up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "notes", "deleted_at", "DATETIME"); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_notes_deleted_at ON notes(deleted_at)`)
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "create note_links table and index existing wiki links",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS note_links (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				source_id INTEGER NOT NULL,
+				target_id INTEGER,
+				target_title TEXT NOT NULL,
+				alias TEXT,
+				position INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (source_id) REFERENCES notes(id) ON DELETE CASCADE
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_note_links_source_id ON note_links(source_id);
+			CREATE INDEX IF NOT EXISTS idx_note_links_target_id ON note_links(target_id);
+			CREATE INDEX IF NOT EXISTS idx_note_links_target_title ON note_links(target_title COLLATE NOCASE);
+			`)
+			if err != nil {
+				return err
+			}
+
+			return backfillNoteLinks(tx)
+		},
+	},
+	{
+		version:     7,
+		description: "create index_outbox table for index synchronization",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS index_outbox (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				note_id INTEGER NOT NULL,
+				target TEXT NOT NULL,
+				op TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT,
+				next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_index_outbox_status ON index_outbox(status, next_attempt_at);
+			CREATE INDEX IF NOT EXISTS idx_index_outbox_note ON index_outbox(note_id, target);
+			`)
+			return err
+		},
+	},
+	{
+		version:     8,
+		description: "create index_state table recording what each index holds",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS index_state (
+				note_id INTEGER NOT NULL,
+				target TEXT NOT NULL,
+				note_updated_at DATETIME NOT NULL,
+				indexed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (note_id, target)
+			)
+			`)
+			return err
+		},
+	},
+	{
+		version:     9,
+		description: "create note_terms table for the local semantic index",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS note_terms (
+				note_id INTEGER NOT NULL,
+				term TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				PRIMARY KEY (note_id, term)
+			)
+			`)
+			return err
+		},
+	},
+	{
+		version:     10,
+		description: "add language column to notes",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "notes", "language", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		version:     11,
+		description: "create notes_fts full-text index kept in sync by triggers",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(
+				title,
+				body,
+				tokenize = 'unicode61 remove_diacritics 2',
+				prefix = '2 3'
+			);
+
+			CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes
+			WHEN new.deleted_at IS NULL
+			BEGIN
+				INSERT INTO notes_fts (rowid, title, body) VALUES (new.id, new.title, note_text(new.content));
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE OF title, content, deleted_at ON notes
+			BEGIN
+				DELETE FROM notes_fts WHERE rowid = old.id;
+				INSERT INTO notes_fts (rowid, title, body)
+				SELECT new.id, new.title, note_text(new.content) WHERE new.deleted_at IS NULL;
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes
+			BEGIN
+				DELETE FROM notes_fts WHERE rowid = old.id;
+			END;
+
+			INSERT INTO notes_fts (rowid, title, body)
+			SELECT id, title, note_text(content) FROM notes WHERE deleted_at IS NULL;
+			`)
+			return err
+		},
+	},
+	{
+		version:     12,
+		description: "create saved_searches table with cached result counts",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS saved_searches (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				query TEXT NOT NULL,
+				mode TEXT NOT NULL,
+				filters TEXT NOT NULL DEFAULT '{}',
+				show_in_sidebar BOOLEAN NOT NULL DEFAULT FALSE,
+				cached_count INTEGER,
+				counted_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			-- Any change to notes or collection memberships may change results
+			CREATE TRIGGER IF NOT EXISTS saved_searches_notes_insert AFTER INSERT ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL WHERE cached_count IS NOT NULL;
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS saved_searches_notes_update AFTER UPDATE OF title, content, deleted_at ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL WHERE cached_count IS NOT NULL;
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS saved_searches_notes_delete AFTER DELETE ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL WHERE cached_count IS NOT NULL;
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS saved_searches_collections_insert AFTER INSERT ON note_collections
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL WHERE cached_count IS NOT NULL;
+			END;
+
+			CREATE TRIGGER IF NOT EXISTS saved_searches_collections_delete AFTER DELETE ON note_collections
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL WHERE cached_count IS NOT NULL;
+			END;
+			`)
+			return err
+		},
+	},
+	{
+		version:     13,
+		description: "create semantic_settings table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS semantic_settings (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				distance_metric TEXT NOT NULL DEFAULT 'cosine',
+				default_threshold REAL NOT NULL DEFAULT 0.3,
+				top_k INTEGER NOT NULL DEFAULT 100,
+				score_min REAL NOT NULL DEFAULT 0,
+				score_max REAL NOT NULL DEFAULT 1,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+
+			INSERT OR IGNORE INTO semantic_settings (id) VALUES (1);
+
+			-- Semantic results depend on the settings
+			CREATE TRIGGER IF NOT EXISTS saved_searches_semantic_settings_update AFTER UPDATE ON semantic_settings
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL
+				WHERE cached_count IS NOT NULL AND mode IN ('semantic', 'hybrid');
+			END;
+			`)
+			return err
+		},
+	},
+	{
+		version:     14,
+		description: "resolve numeric links without an alias by title",
+		up: func(tx *sql.Tx) error {
+			// Only [[id|alias]] links refer to a note ID; [[2024]] is a title
+			_, err := tx.Exec(`UPDATE note_links SET target_id = NULL WHERE COALESCE(alias, '') = ''`)
+			return err
+		},
+	},
+	{
+		version:     15,
+		description: "count changes to saved search results so stale counts are not cached",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			ALTER TABLE saved_searches ADD COLUMN count_version INTEGER NOT NULL DEFAULT 0;
+
+			DROP TRIGGER IF EXISTS saved_searches_notes_insert;
+			DROP TRIGGER IF EXISTS saved_searches_notes_update;
+			DROP TRIGGER IF EXISTS saved_searches_notes_delete;
+			DROP TRIGGER IF EXISTS saved_searches_collections_insert;
+			DROP TRIGGER IF EXISTS saved_searches_collections_delete;
+			DROP TRIGGER IF EXISTS saved_searches_semantic_settings_update;
+
+			-- Every change bumps count_version, even with no count cached, so a
+			-- count taken before the change is not stored after it
+			CREATE TRIGGER saved_searches_notes_insert AFTER INSERT ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1;
+			END;
+
+			CREATE TRIGGER saved_searches_notes_update AFTER UPDATE OF title, content, deleted_at ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1;
+			END;
+
+			CREATE TRIGGER saved_searches_notes_delete AFTER DELETE ON notes
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1;
+			END;
+
+			CREATE TRIGGER saved_searches_collections_insert AFTER INSERT ON note_collections
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1;
+			END;
+
+			CREATE TRIGGER saved_searches_collections_delete AFTER DELETE ON note_collections
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1;
+			END;
+
+			CREATE TRIGGER saved_searches_semantic_settings_update AFTER UPDATE ON semantic_settings
+			BEGIN
+				UPDATE saved_searches SET cached_count = NULL, count_version = count_version + 1
+				WHERE mode IN ('semantic', 'hybrid');
+			END;
+			`)
+			return err
+		},
+	},
+	{
+		version:     16,
+		description: "embed plain text instead of HTML in SemWare",
+		up: func(tx *sql.Tx) error {
+			// Reconciliation sends notes without index state again
+			_, err := tx.Exec(`DELETE FROM index_state WHERE target = 'semware'`)
+			return err
+		},
+	},
+	{
+		version:     17,
+		description: "keep notes_fts in sync from Go instead of triggers",
+		up: func(tx *sql.Tx) error {
+			// The triggers called note_text, which only this process
+			// provides, so writes from any other SQLite client failed
+			_, err := tx.Exec(`
+			DROP TRIGGER IF EXISTS notes_fts_insert;
+			DROP TRIGGER IF EXISTS notes_fts_update;
+			DROP TRIGGER IF EXISTS notes_fts_delete;
+			`)
+			return err
+		},
+	},
+}
+
+// backfillNoteLinks parses links out of every existing note
+func backfillNoteLinks(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, content FROM notes`)
+	if err != nil {
+		return err
+	}
+
+	contents := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		contents[id] = content
+	}
+	rows.Close()
+
+	for id, content := range contents {
+		if err := saveNoteLinks(tx, id, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// latestVersion is the schema version this binary expects
+func latestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// runMigrations applies every pending migration, each in its own transaction.
+// It refuses to touch a database whose schema is newer than this binary.
+func runMigrations(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current > latestVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d); upgrade zendown before using this database", current, latestVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, description) VALUES (?, ?)`, m.version, m.description)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations reports the current schema version of the database at
+// dbPath and the migrations that NewDB would apply, without changing anything.
+func PendingMigrations(dbPath string) (int, []MigrationInfo, error) {
+	current := 0
+
+	if _, err := os.Stat(dbPath); err == nil {
+		db, err := sql.Open("sqlite", "file:"+dbPath+"?mode=ro")
+		if err != nil {
+			return 0, nil, err
+		}
+		defer db.Close()
+
+		var exists int
+		err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&exists)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if exists > 0 {
+			current, err = schemaVersion(db)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, nil, err
+	}
+
+	if current > latestVersion() {
+		return current, nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, latestVersion())
+	}
+
+	var pending []MigrationInfo
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, MigrationInfo{Version: m.version, Description: m.description})
+		}
+	}
+
+	return current, pending, nil
+}
+
+// addColumnIfMissing adds a column unless it already exists. Databases created
+// before the migration system may already have columns that a migration adds.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?", table), column).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,59 @@
+package database
+
+// SaveNoteTerms replaces the term counts stored for a note
+func (db *DB) SaveNoteTerms(noteID int64, counts map[string]int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM note_terms WHERE note_id = ?`, noteID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO note_terms (note_id, term, count) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for term, count := range counts {
+		if _, err := stmt.Exec(noteID, term, count); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteNoteTerms removes the term counts stored for a note
+func (db *DB) DeleteNoteTerms(noteID int64) error {
+	_, err := db.Exec(`DELETE FROM note_terms WHERE note_id = ?`, noteID)
+	return err
+}
+
+// GetAllNoteTerms returns the term counts of every note, keyed by note ID
+func (db *DB) GetAllNoteTerms() (map[int64]map[string]int, error) {
+	rows, err := db.Query(`SELECT note_id, term, count FROM note_terms`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make(map[int64]map[string]int)
+	for rows.Next() {
+		var noteID int64
+		var term string
+		var count int
+		if err := rows.Scan(&noteID, &term, &count); err != nil {
+			return nil, err
+		}
+		if terms[noteID] == nil {
+			terms[noteID] = make(map[string]int)
+		}
+		terms[noteID][term] = count
+	}
+
+	return terms, nil
+}
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Index targets kept in sync with the notes table through the outbox
+const (
+	IndexTargetSemWare = "semware"
+	IndexTargetLocal   = "local"
+	IndexTargetBM25    = "bm25"
+)
+
+// Index operations
+const (
+	IndexOpUpsert = "upsert"
+	IndexOpDelete = "delete"
+)
+
+// Index job states. Completed jobs are deleted rather than kept around.
+const (
+	IndexJobPending = "pending"
+	IndexJobFailed  = "failed"
+)
+
+// AllIndexTargets lists every index that can receive outbox jobs
+var AllIndexTargets = []string{IndexTargetSemWare, IndexTargetLocal, IndexTargetBM25}
+
+// IndexJob is a pending change that still has to be applied to an index
+type IndexJob struct {
+	ID            int64     `json:"id"`
+	NoteID        int64     `json:"note_id"`
+	Target        string    `json:"target"`
+	Op            string    `json:"op"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// IndexTargets returns the indexes that receive outbox jobs
+func (db *DB) IndexTargets() []string {
+	return db.indexTargets
+}
+
+// SetIndexTargets narrows the indexes that receive outbox jobs to the ones
+// the configured backends use. Call it before notes change.
+func (db *DB) SetIndexTargets(targets []string) {
+	db.indexTargets = targets
+}
+
+// enqueueIndexJobs records that a note must be upserted into or deleted from
+// every index. It runs inside the transaction that changes the note so the
+// outbox can never miss a change.
+func (db *DB) enqueueIndexJobs(tx *sql.Tx, noteID int64, op string) error {
+	for _, target := range db.indexTargets {
+		if err := enqueueIndexJob(tx, noteID, target, op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueIndexJob queues one operation for one index. A job identical to the
+// latest pending one for the same note and target is skipped, since upserts
+// always read the current note when they run.
+func enqueueIndexJob(tx *sql.Tx, noteID int64, target, op string) error {
+	var lastOp string
+	err := tx.QueryRow(`
+	SELECT op FROM index_outbox
+	WHERE note_id = ? AND target = ? AND status = ? AND attempts = 0
+	ORDER BY id DESC
+	LIMIT 1
+	`, noteID, target, IndexJobPending).Scan(&lastOp)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if lastOp == op {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO index_outbox (note_id, target, op, status, next_attempt_at, created_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, noteID, target, op, IndexJobPending)
+	return err
+}
+
+// EnqueueIndexJobs queues an index operation for a note outside of a note change
+func (db *DB) EnqueueIndexJobs(noteID int64, op string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.enqueueIndexJobs(tx, noteID, op); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EnqueueIndexJob queues an index operation for a single index
+func (db *DB) EnqueueIndexJob(noteID int64, target, op string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueueIndexJob(tx, noteID, target, op); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReadyIndexJobs returns pending jobs whose retry time has come. A job is
+// only ready once every earlier pending job for the same note and target has
+// completed, which keeps operations on a note in order.
+func (db *DB) GetReadyIndexJobs(limit int) ([]*IndexJob, error) {
+	query := `
+	SELECT o.id, o.note_id, o.target, o.op, o.status, o.attempts, COALESCE(o.last_error, ''), o.next_attempt_at, o.created_at
+	FROM index_outbox o
+	WHERE o.status = ? AND o.next_attempt_at <= CURRENT_TIMESTAMP
+	AND NOT EXISTS (
+		SELECT 1 FROM index_outbox e
+		WHERE e.note_id = o.note_id AND e.target = o.target AND e.status = ? AND e.id < o.id
+	)
+	ORDER BY o.id
+	LIMIT ?
+	`
+
+	return db.queryIndexJobs(query, IndexJobPending, IndexJobPending, limit)
+}
+
+// GetIndexJobs lists outbox jobs with the given status, oldest first
+func (db *DB) GetIndexJobs(status string, limit int) ([]*IndexJob, error) {
+	query := `
+	SELECT id, note_id, target, op, status, attempts, COALESCE(last_error, ''), next_attempt_at, created_at
+	FROM index_outbox
+	WHERE status = ?
+	ORDER BY id
+	LIMIT ?
+	`
+
+	return db.queryIndexJobs(query, status, limit)
+}
+
+func (db *DB) queryIndexJobs(query string, args ...interface{}) ([]*IndexJob, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*IndexJob
+	for rows.Next() {
+		job := &IndexJob{}
+		err := rows.Scan(
+			&job.ID,
+			&job.NoteID,
+			&job.Target,
+			&job.Op,
+			&job.Status,
+			&job.Attempts,
+			&job.LastError,
+			&job.NextAttemptAt,
+			&job.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// CountIndexJobs returns the number of outbox jobs per status
+func (db *DB) CountIndexJobs() (map[string]int, error) {
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM index_outbox GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{IndexJobPending: 0, IndexJobFailed: 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, nil
+}
+
+// CountPendingIndexJobs returns the number of pending jobs for the given
+// targets, or for every target if none are given
+func (db *DB) CountPendingIndexJobs(targets ...string) (int, error) {
+	query := `SELECT COUNT(*) FROM index_outbox WHERE status = ?`
+	args := []interface{}{IndexJobPending}
+	if len(targets) > 0 {
+		query += ` AND target IN (?` + strings.Repeat(`, ?`, len(targets)-1) + `)`
+		for _, target := range targets {
+			args = append(args, target)
+		}
+	}
+
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// CompleteIndexJob removes a job that was applied successfully
+func (db *DB) CompleteIndexJob(id int64) error {
+	_, err := db.Exec(`DELETE FROM index_outbox WHERE id = ?`, id)
+	return err
+}
+
+// StartIndexJob counts an attempt before the job runs. Once a job has been
+// started, new changes to the note get their own job instead of being folded
+// into it, since the running attempt may already have read the old state.
+func (db *DB) StartIndexJob(id int64) error {
+	_, err := db.Exec(`UPDATE index_outbox SET attempts = attempts + 1 WHERE id = ?`, id)
+	return err
+}
+
+// RetryIndexJobLater records why an attempt failed and schedules the next
+// one. When giveUp is true the job is marked failed and no longer retried.
+func (db *DB) RetryIndexJobLater(id int64, cause error, nextAttempt time.Time, giveUp bool) error {
+	status := IndexJobPending
+	if giveUp {
+		status = IndexJobFailed
+	}
+
+	_, err := db.Exec(`
+	UPDATE index_outbox
+	SET last_error = ?, status = ?, next_attempt_at = ?
+	WHERE id = ?
+	`, cause.Error(), status, nextAttempt.UTC().Format("2006-01-02 15:04:05"), id)
+	return err
+}
+
+// RequeueFailedIndexJobs moves failed jobs back to pending. A failed job
+// that a newer job for the same note and target supersedes is dropped
+// instead, so an old delete cannot replay after a newer upsert.
+func (db *DB) RequeueFailedIndexJobs() (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	DELETE FROM index_outbox
+	WHERE status = ? AND EXISTS (
+		SELECT 1 FROM index_outbox n
+		WHERE n.note_id = index_outbox.note_id AND n.target = index_outbox.target AND n.id > index_outbox.id
+	)
+	`, IndexJobFailed)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+	UPDATE index_outbox
+	SET status = ?, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`, IndexJobPending, IndexJobFailed)
+	if err != nil {
+		return 0, err
+	}
+
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return requeued, tx.Commit()
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RevisionWindow is the period during which consecutive saves of a note are
+// folded into its latest revision instead of creating a new one. It keeps
+// editor auto-save bursts from flooding the history.
+var RevisionWindow = 2 * time.Minute
+
+// MaxRevisionsPerNote caps how many revisions are kept for a single note.
+// Older revisions beyond this limit are pruned whenever a new one is written,
+// except for the first one.
+var MaxRevisionsPerNote = 200
+
+type Revision struct {
+	ID        int64     `json:"id"`
+	NoteID    int64     `json:"note_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content,omitempty"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// recordRevision snapshots the current state of a note into note_revisions.
+// When coalesce is true and the latest revision was started within
+// RevisionWindow, that revision is overwritten instead of adding a new row.
+// The note's first revision holds its state at creation and is never
+// overwritten.
+func recordRevision(tx *sql.Tx, noteID int64, coalesce bool) error {
+	var title, content string
+	err := tx.QueryRow(`SELECT title, content FROM notes WHERE id = ?`, noteID).Scan(&title, &content)
+	if err != nil {
+		return err
+	}
+
+	var latestID int64
+	var latestTitle, latestContent string
+	var recent, first bool
+	err = tx.QueryRow(`
+	SELECT id, title, content, created_at >= datetime('now', ?),
+		id = (SELECT MIN(id) FROM note_revisions WHERE note_id = ?)
+	FROM note_revisions
+	WHERE note_id = ?
+	ORDER BY id DESC
+	LIMIT 1
+	`, fmt.Sprintf("-%d seconds", int64(RevisionWindow/time.Second)), noteID, noteID).Scan(&latestID, &latestTitle, &latestContent, &recent, &first)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Nothing changed since the last snapshot
+	if latestID != 0 && latestTitle == title && latestContent == content {
+		return nil
+	}
+
+	if coalesce && RevisionWindow > 0 && latestID != 0 && recent && !first {
+		_, err = tx.Exec(`
+		UPDATE note_revisions
+		SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		`, title, content, latestID)
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO note_revisions (note_id, title, content, created_at, updated_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, noteID, title, content)
+	if err != nil {
+		return err
+	}
+
+	return pruneRevisions(tx, noteID)
+}
+
+// recordFirstRevision snapshots a note that has no revisions yet, dated when
+// the note was last updated. Notes created before revisions were kept start
+// their history this way on their first edit.
+func recordFirstRevision(tx *sql.Tx, noteID int64) error {
+	_, err := tx.Exec(`
+	INSERT INTO note_revisions (note_id, title, content, created_at, updated_at)
+	SELECT id, title, content, updated_at, updated_at
+	FROM notes
+	WHERE id = ? AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id = ?)
+	`, noteID, noteID)
+	return err
+}
+
+// pruneRevisions drops the oldest revisions beyond MaxRevisionsPerNote. The
+// first revision is kept, as it is the only copy of the note as created.
+func pruneRevisions(tx *sql.Tx, noteID int64) error {
+	if MaxRevisionsPerNote <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+	DELETE FROM note_revisions
+	WHERE note_id = ?
+		AND id != (SELECT MIN(id) FROM note_revisions WHERE note_id = ?)
+		AND id NOT IN (
+			SELECT id FROM note_revisions
+			WHERE note_id = ?
+			ORDER BY id DESC
+			LIMIT ?
+		)
+	`, noteID, noteID, noteID, max(MaxRevisionsPerNote-1, 1))
+	return err
+}
+
+// GetNoteRevisions lists the revisions of a note, newest first. Content is
+// left out to keep the listing small; use GetNoteRevision to fetch it.
+func (db *DB) GetNoteRevisions(noteID int64) ([]*Revision, error) {
+	query := `
+	SELECT id, note_id, title, LENGTH(content), created_at, updated_at
+	FROM note_revisions
+	WHERE note_id = ?
+	ORDER BY id DESC
+	`
+
+	rows, err := db.Query(query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*Revision
+	for rows.Next() {
+		revision := &Revision{}
+		err := rows.Scan(
+			&revision.ID,
+			&revision.NoteID,
+			&revision.Title,
+			&revision.Size,
+			&revision.CreatedAt,
+			&revision.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+func (db *DB) GetNoteRevision(noteID, revisionID int64) (*Revision, error) {
+	query := `
+	SELECT id, note_id, title, content, LENGTH(content), created_at, updated_at
+	FROM note_revisions
+	WHERE note_id = ? AND id = ?
+	`
+
+	revision := &Revision{}
+	err := db.QueryRow(query, noteID, revisionID).Scan(
+		&revision.ID,
+		&revision.NoteID,
+		&revision.Title,
+		&revision.Content,
+		&revision.Size,
+		&revision.CreatedAt,
+		&revision.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// RestoreNoteRevision copies a revision back into the note. The restored
+// state is always recorded as a fresh revision so the restore itself can be
+// undone.
+func (db *DB) RestoreNoteRevision(noteID, revisionID int64) (*Note, error) {
+	revision, err := db.GetNoteRevision(noteID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE notes
+	SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`, revision.Title, revision.Content, noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err := recordRevision(tx, noteID, false); err != nil {
+		return nil, err
+	}
+
+	if err := saveNoteLinks(tx, noteID, revision.Content); err != nil {
+		return nil, err
+	}
+
+	if err := syncNoteFTS(tx, noteID); err != nil {
+		return nil, err
+	}
+
+	if err := db.enqueueIndexJobs(tx, noteID, IndexOpUpsert); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return db.GetNote(noteID)
+}
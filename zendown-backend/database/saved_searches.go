@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Saved search modes, one per search endpoint
+const (
+	SearchModeLike     = "like"
+	SearchModeBM25     = "bm25"
+	SearchModeSemantic = "semantic"
+	SearchModeHybrid   = "hybrid"
+)
+
+// SearchModes lists every saved search mode
+var SearchModes = []string{SearchModeLike, SearchModeBM25, SearchModeSemantic, SearchModeHybrid}
+
+// SavedSearchFilters narrow the results of a saved search in every mode.
+// Created and Updated take the date syntax of the created: and updated:
+// query filters.
+type SavedSearchFilters struct {
+	Collection string `json:"collection,omitempty"`
+	Created    string `json:"created,omitempty"`
+	Updated    string `json:"updated,omitempty"`
+	// Threshold is the minimum similarity for semantic and hybrid searches
+	Threshold *float64 `json:"threshold,omitempty"`
+	// Limit caps the number of results; 0 uses the endpoint default
+	Limit int `json:"limit,omitempty"`
+}
+
+// SavedSearch is a query kept for re-running. CachedCount is the number of
+// results when last run, or nil once notes changed since. CountVersion
+// changes whenever the results may have, so a count can be checked against
+// the version read before it was taken.
+type SavedSearch struct {
+	ID            int64              `json:"id"`
+	Name          string             `json:"name"`
+	Query         string             `json:"query"`
+	Mode          string             `json:"mode"`
+	Filters       SavedSearchFilters `json:"filters"`
+	ShowInSidebar bool               `json:"show_in_sidebar"`
+	CachedCount   *int               `json:"cached_count"`
+	CountedAt     *time.Time         `json:"counted_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	CountVersion  int64              `json:"-"`
+}
+
+const savedSearchColumns = `id, name, query, mode, filters, show_in_sidebar, cached_count, counted_at, created_at, updated_at, count_version`
+
+func scanSavedSearch(scan func(dest ...interface{}) error) (*SavedSearch, error) {
+	search := &SavedSearch{}
+	var filters string
+	var count sql.NullInt64
+	err := scan(
+		&search.ID,
+		&search.Name,
+		&search.Query,
+		&search.Mode,
+		&filters,
+		&search.ShowInSidebar,
+		&count,
+		&search.CountedAt,
+		&search.CreatedAt,
+		&search.UpdatedAt,
+		&search.CountVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(filters), &search.Filters); err != nil {
+		return nil, err
+	}
+	if count.Valid {
+		n := int(count.Int64)
+		search.CachedCount = &n
+	}
+
+	return search, nil
+}
+
+// CreateSavedSearch stores a new saved search
+func (db *DB) CreateSavedSearch(search *SavedSearch) (*SavedSearch, error) {
+	filters, err := json.Marshal(search.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO saved_searches (name, query, mode, filters, show_in_sidebar, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+
+	result, err := db.Exec(query, search.Name, search.Query, search.Mode, string(filters), search.ShowInSidebar)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetSavedSearch(id)
+}
+
+func (db *DB) GetSavedSearch(id int64) (*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches WHERE id = ?`
+	return scanSavedSearch(db.QueryRow(query, id).Scan)
+}
+
+// GetSavedSearches lists saved searches by name, only those shown in the
+// sidebar if sidebarOnly is set
+func (db *DB) GetSavedSearches(sidebarOnly bool) ([]*SavedSearch, error) {
+	query := `
+	SELECT ` + savedSearchColumns + `
+	FROM saved_searches
+	WHERE show_in_sidebar OR NOT ?
+	ORDER BY name COLLATE NOCASE ASC
+	`
+
+	rows, err := db.Query(query, sidebarOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+
+	return searches, nil
+}
+
+// UpdateSavedSearch replaces a saved search and drops its cached count
+func (db *DB) UpdateSavedSearch(search *SavedSearch) (*SavedSearch, error) {
+	filters, err := json.Marshal(search.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE saved_searches
+	SET name = ?, query = ?, mode = ?, filters = ?, show_in_sidebar = ?,
+		cached_count = NULL, counted_at = NULL, count_version = count_version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+
+	result, err := db.Exec(query, search.Name, search.Query, search.Mode, string(filters), search.ShowInSidebar, search.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return db.GetSavedSearch(search.ID)
+}
+
+func (db *DB) DeleteSavedSearch(id int64) error {
+	result, err := db.Exec(`DELETE FROM saved_searches WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetSavedSearchCount caches the result count of a saved search, unless its
+// count version moved on from version since the count was taken. Triggers on
+// notes and note_collections clear it again when anything changes.
+func (db *DB) SetSavedSearchCount(id int64, count int, version int64) error {
+	query := `
+	UPDATE saved_searches SET cached_count = ?, counted_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND count_version = ?
+	`
+	_, err := db.Exec(query, count, id, version)
+	return err
+}
@@ -0,0 +1,74 @@
+package database
+
+import "time"
+
+// Distance metrics SemWare can rank embeddings by
+const (
+	DistanceMetricCosine = "cosine"
+	DistanceMetricL2     = "l2"
+	DistanceMetricDot    = "dot"
+)
+
+// DistanceMetrics lists every distance metric
+var DistanceMetrics = []string{DistanceMetricCosine, DistanceMetricL2, DistanceMetricDot}
+
+// SemanticSettings tune every semantic query. Scores reported by SemWare are
+// mapped from [ScoreMin, ScoreMax] onto [0, 1], higher meaning more similar,
+// and thresholds, including DefaultThreshold, apply to the mapped scores.
+// Cosine and dot scores are similarities and map ScoreMax to 1; l2 scores
+// are distances and map ScoreMin to 1. TopK is the number of
+// nearest notes asked for unless a request gives its own.
+type SemanticSettings struct {
+	DistanceMetric   string    `json:"distance_metric"`
+	DefaultThreshold float64   `json:"default_threshold"`
+	TopK             int       `json:"top_k"`
+	ScoreMin         float64   `json:"score_min"`
+	ScoreMax         float64   `json:"score_max"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// DefaultSemanticSettings are the settings of a new database
+func DefaultSemanticSettings() SemanticSettings {
+	return SemanticSettings{
+		DistanceMetric:   DistanceMetricCosine,
+		DefaultThreshold: 0.3,
+		TopK:             100,
+		ScoreMin:         0,
+		ScoreMax:         1,
+	}
+}
+
+func (db *DB) GetSemanticSettings() (*SemanticSettings, error) {
+	query := `
+	SELECT distance_metric, default_threshold, top_k, score_min, score_max, updated_at
+	FROM semantic_settings
+	WHERE id = 1`
+
+	settings := &SemanticSettings{}
+	err := db.QueryRow(query).Scan(
+		&settings.DistanceMetric,
+		&settings.DefaultThreshold,
+		&settings.TopK,
+		&settings.ScoreMin,
+		&settings.ScoreMax,
+		&settings.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSemanticSettings stores settings and returns them as saved
+func (db *DB) UpdateSemanticSettings(settings SemanticSettings) (*SemanticSettings, error) {
+	query := `
+	UPDATE semantic_settings
+	SET distance_metric = ?, default_threshold = ?, top_k = ?, score_min = ?, score_max = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = 1`
+
+	_, err := db.Exec(query, settings.DistanceMetric, settings.DefaultThreshold, settings.TopK, settings.ScoreMin, settings.ScoreMax)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetSemanticSettings()
+}
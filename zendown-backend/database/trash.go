@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetTrashedNotes returns all notes in the trash, most recently deleted first
+func (db *DB) GetTrashedNotes() ([]*Note, error) {
+	query := `
+	SELECT id, title, content, created_at, updated_at, COALESCE(language, ''), deleted_at
+	FROM notes
+	WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []*Note
+	for rows.Next() {
+		note := &Note{}
+		err := rows.Scan(
+			&note.ID,
+			&note.Title,
+			&note.Content,
+			&note.CreatedAt,
+			&note.UpdatedAt,
+			&note.Language,
+			&note.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, nil
+}
+
+// RestoreNote takes a note out of the trash. Its collection memberships were
+// never removed, so they become visible again as soon as deleted_at is cleared.
+func (db *DB) RestoreNote(id int64) (*Note, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE notes SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err := syncNoteFTS(tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := db.enqueueIndexJobs(tx, id, IndexOpUpsert); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return db.GetNote(id)
+}
+
+// DeleteNotePermanently removes a trashed note together with its revisions
+// and collection memberships
+func (db *DB) DeleteNotePermanently(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.purgeNote(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes notes that have been in the trash for longer
+// than retention and returns the IDs of the purged notes
+func (db *DB) PurgeTrash(retention time.Duration) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT id FROM notes
+	WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?)
+	`, fmt.Sprintf("-%d seconds", int64(retention/time.Second)))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := db.purgeNote(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (db *DB) purgeNote(tx *sql.Tx, id int64) error {
+	result, err := tx.Exec(`DELETE FROM notes WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM note_collections WHERE note_id = ?`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM note_links WHERE source_id = ?`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM note_revisions WHERE note_id = ?`, id); err != nil {
+		return err
+	}
+
+	// The note left the indexes when it was trashed; repeat the delete in
+	// case that never went through
+	if err := syncNoteFTS(tx, id); err != nil {
+		return err
+	}
+	return db.enqueueIndexJobs(tx, id, IndexOpDelete)
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Op describes what happened to a line between two versions of a text
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+type Stats struct {
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+}
+
+// maxCells bounds the size of the LCS table. Larger inputs fall back to a
+// coarse replace of the differing middle section.
+const maxCells = 4_000_000
+
+// blockEndRegex matches closing tags of block-level elements produced by the editor
+var blockEndRegex = regexp.MustCompile(`(?i)(</(?:p|h[1-6]|li|ul|ol|pre|blockquote|div|table|tr)>|<br\s*/?>|<hr\s*/?>)`)
+
+// SplitHTML breaks editor HTML into one line per block element so that a
+// line diff reports paragraph-level changes instead of a single huge line.
+func SplitHTML(content string) []string {
+	content = blockEndRegex.ReplaceAllString(content, "$1\n")
+
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Lines computes a line-based diff turning a into b
+func Lines(a, b []string) []Line {
+	// Trim common prefix and suffix to keep the LCS table small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var result []Line
+	for _, line := range a[:prefix] {
+		result = append(result, Line{Op: Equal, Text: line})
+	}
+
+	result = append(result, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, Line{Op: Equal, Text: line})
+	}
+
+	return result
+}
+
+// Summarize counts inserted and deleted lines
+func Summarize(lines []Line) Stats {
+	var stats Stats
+	for _, line := range lines {
+		switch line.Op {
+		case Insert:
+			stats.Insertions++
+		case Delete:
+			stats.Deletions++
+		}
+	}
+	return stats
+}
+
+func middle(a, b []string) []Line {
+	var result []Line
+
+	if (len(a)+1)*(len(b)+1) > maxCells {
+		for _, line := range a {
+			result = append(result, Line{Op: Delete, Text: line})
+		}
+		for _, line := range b {
+			result = append(result, Line{Op: Insert, Text: line})
+		}
+		return result
+	}
+
+	// lcs[i][j] holds the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, Line{Op: Equal, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, Line{Op: Delete, Text: a[i]})
+			i++
+		default:
+			result = append(result, Line{Op: Insert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, Line{Op: Delete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, Line{Op: Insert, Text: b[j]})
+	}
+
+	return result
+}
@@ -0,0 +1,93 @@
+package embedding
+
+import (
+	"context"
+	"log"
+)
+
+// Match is a note similar to a query, with a score between 0 and 1.
+// Passages are the parts of the note that matched, best first, when the
+// store reports them.
+type Match struct {
+	ID       int64     `json:"id"`
+	Score    float64   `json:"score"`
+	Passages []Passage `json:"passages,omitempty"`
+}
+
+// Passage is a chunk of a note that matched. Start and End are character
+// offsets into the note's plain text as notetext.Plain extracts it from the
+// HTML content, which is what the stores embed; End is 0 when the store did
+// not report them.
+type Passage struct {
+	Chunk   int     `json:"chunk_index"`
+	Excerpt string  `json:"excerpt"`
+	Start   int     `json:"start"`
+	End     int     `json:"end"`
+	Score   float64 `json:"score"`
+}
+
+// VectorStore embeds notes and answers similarity queries over them. SemWare
+// and the in-process TF-IDF index both implement it. Calls stop when ctx ends.
+type VectorStore interface {
+	// Upsert embeds a note's content, replacing any previous version
+	Upsert(ctx context.Context, noteID int64, content string) error
+	// Delete removes a note; deleting a missing note is not an error
+	Delete(ctx context.Context, noteID int64) error
+	// Similar returns up to topK notes similar to an indexed note, excluding
+	// the note itself, best first. A topK of 0 leaves the number to the store.
+	Similar(ctx context.Context, noteID int64, threshold float64, topK int) ([]Match, error)
+	// Search returns up to topK notes similar to free text, best first. A topK
+	// of 0 leaves the number to the store.
+	Search(ctx context.Context, query string, threshold float64, topK int) ([]Match, error)
+}
+
+// Fallback answers queries from Primary and falls back to Secondary when
+// Primary fails, so semantic features keep working while an external
+// service is down. Writes go to both stores.
+type Fallback struct {
+	Primary   VectorStore
+	Secondary VectorStore
+}
+
+func (f *Fallback) Upsert(ctx context.Context, noteID int64, content string) error {
+	if err := f.Primary.Upsert(ctx, noteID, content); err != nil {
+		return err
+	}
+	return f.Secondary.Upsert(ctx, noteID, content)
+}
+
+func (f *Fallback) Delete(ctx context.Context, noteID int64) error {
+	if err := f.Primary.Delete(ctx, noteID); err != nil {
+		return err
+	}
+	return f.Secondary.Delete(ctx, noteID)
+}
+
+func (f *Fallback) Similar(ctx context.Context, noteID int64, threshold float64, topK int) ([]Match, error) {
+	matches, err := f.Primary.Similar(ctx, noteID, threshold, topK)
+	if err != nil {
+		log.Printf("Primary vector store failed, using fallback: %v", err)
+		return f.Secondary.Similar(ctx, noteID, threshold, topK)
+	}
+	return matches, nil
+}
+
+func (f *Fallback) Search(ctx context.Context, query string, threshold float64, topK int) ([]Match, error) {
+	matches, err := f.Primary.Search(ctx, query, threshold, topK)
+	if err != nil {
+		log.Printf("Primary vector store failed, using fallback: %v", err)
+		return f.Secondary.Search(ctx, query, threshold, topK)
+	}
+	return matches, nil
+}
+
+// Semantic backends selectable with SEMANTIC_BACKEND
+const (
+	// BackendAuto uses SemWare and falls back to the local index when
+	// SemWare is unreachable
+	BackendAuto = "auto"
+	// BackendSemWare only uses SemWare
+	BackendSemWare = "semware"
+	// BackendLocal only uses the in-process TF-IDF index
+	BackendLocal = "local"
+)
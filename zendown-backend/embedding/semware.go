@@ -0,0 +1,150 @@
+package embedding
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"zendown/notetext"
+	"zendown/semware"
+)
+
+// SemWareStore is a VectorStore backed by the SemWare service
+type SemWareStore struct {
+	client *semware.Client
+
+	mu     sync.RWMutex
+	config SemWareConfig
+}
+
+// SemWareConfig tunes SemWare queries. SemWare's scores are mapped from
+// [ScoreMin, ScoreMax] onto [0, 1], higher meaning more similar, so they
+// compare with the local index's; thresholds passed to the store apply to the
+// mapped scores. Under the l2 metric SemWare reports distances, where lower
+// means more similar, so the mapping is inverted.
+type SemWareConfig struct {
+	DistanceMetric string
+	ScoreMin       float64
+	ScoreMax       float64
+}
+
+func DefaultSemWareConfig() SemWareConfig {
+	return SemWareConfig{
+		DistanceMetric: "cosine",
+		ScoreMin:       0,
+		ScoreMax:       1,
+	}
+}
+
+func NewSemWareStore(client *semware.Client) *SemWareStore {
+	return &SemWareStore{client: client, config: DefaultSemWareConfig()}
+}
+
+// Configure changes how later queries are made and scored
+func (s *SemWareStore) Configure(config SemWareConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = config
+}
+
+func (s *SemWareStore) currentConfig() SemWareConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// isDistance reports whether SemWare's scores are distances, lower meaning
+// more similar, rather than similarities
+func (c SemWareConfig) isDistance() bool {
+	return c.DistanceMetric == "l2"
+}
+
+// rawScore maps a normalized score back onto SemWare's scale
+func (c SemWareConfig) rawScore(score float64) float64 {
+	if c.isDistance() {
+		return c.ScoreMax - score*(c.ScoreMax-c.ScoreMin)
+	}
+	return c.ScoreMin + score*(c.ScoreMax-c.ScoreMin)
+}
+
+// normalize maps a SemWare score onto [0, 1]
+func (c SemWareConfig) normalize(score float64) float64 {
+	if c.ScoreMax <= c.ScoreMin {
+		return score
+	}
+	if c.isDistance() {
+		return min(max((c.ScoreMax-score)/(c.ScoreMax-c.ScoreMin), 0), 1)
+	}
+	return min(max((score-c.ScoreMin)/(c.ScoreMax-c.ScoreMin), 0), 1)
+}
+
+// Upsert sends the plain text of content, so SemWare's chunk offsets count
+// characters of the text rather than of the HTML
+func (s *SemWareStore) Upsert(ctx context.Context, noteID int64, content string) error {
+	_, err := s.client.UpsertDocument(ctx, strconv.FormatInt(noteID, 10), notetext.Plain(content))
+	return err
+}
+
+func (s *SemWareStore) Delete(ctx context.Context, noteID int64) error {
+	return s.client.DeleteDocument(ctx, strconv.FormatInt(noteID, 10))
+}
+
+func (s *SemWareStore) Similar(ctx context.Context, noteID int64, threshold float64, topK int) ([]Match, error) {
+	config := s.currentConfig()
+	response, err := s.client.GetSimilarDocuments(ctx, strconv.FormatInt(noteID, 10), config.queryOptions(threshold, topK))
+	if err != nil {
+		return nil, err
+	}
+	return config.toMatches(response.SimilarResults, threshold), nil
+}
+
+func (s *SemWareStore) Search(ctx context.Context, query string, threshold float64, topK int) ([]Match, error) {
+	config := s.currentConfig()
+	response, err := s.client.SemanticSearch(ctx, query, config.queryOptions(threshold, topK))
+	if err != nil {
+		return nil, err
+	}
+	return config.toMatches(response.SimilarResults, threshold), nil
+}
+
+func (c SemWareConfig) queryOptions(threshold float64, topK int) semware.QueryOptions {
+	return semware.QueryOptions{
+		Threshold:      c.rawScore(threshold),
+		TopK:           topK,
+		DistanceMetric: c.DistanceMetric,
+	}
+}
+
+// toMatches converts SemWare results, whose document IDs are note IDs, to
+// normalized scores at or above threshold
+func (c SemWareConfig) toMatches(results []semware.SimilarResult, threshold float64) []Match {
+	var matches []Match
+	for _, result := range results {
+		noteID, err := strconv.ParseInt(result.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+		score := c.normalize(result.Score)
+		if score < threshold {
+			continue
+		}
+		matches = append(matches, Match{ID: noteID, Score: score, Passages: c.toPassages(result.Chunks)})
+	}
+	return matches
+}
+
+func (c SemWareConfig) toPassages(chunks []semware.ChunkResult) []Passage {
+	var passages []Passage
+	for _, chunk := range chunks {
+		passages = append(passages, Passage{
+			Chunk:   chunk.Index,
+			Excerpt: chunk.Text,
+			Start:   chunk.StartOffset,
+			End:     chunk.EndOffset,
+			Score:   c.normalize(chunk.Score),
+		})
+	}
+	return passages
+}
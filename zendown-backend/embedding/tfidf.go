@@ -0,0 +1,216 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"zendown/database"
+	"zendown/notetext"
+)
+
+// stopWords are common English words that carry no topical meaning
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "has": true,
+	"have": true, "he": true, "her": true, "his": true, "i": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "me": true,
+	"my": true, "no": true, "not": true, "of": true, "on": true, "or": true,
+	"our": true, "she": true, "so": true, "that": true, "the": true, "their": true,
+	"them": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "we": true, "were": true, "what": true,
+	"when": true, "which": true, "who": true, "will": true, "with": true,
+	"you": true, "your": true,
+}
+
+// TFIDFStore is an in-process VectorStore that needs no external service.
+// Term counts are stored in SQLite and notes are compared by cosine
+// similarity of their TF-IDF vectors, with IDF computed from the current
+// corpus at query time. Scores are lexical rather than semantic and tend to
+// be lower than those of an embedding model, so lower thresholds work better.
+type TFIDFStore struct {
+	db *database.DB
+
+	mu     sync.RWMutex
+	loaded bool
+	terms  map[int64]map[string]int
+	df     map[string]int
+}
+
+func NewTFIDFStore(db *database.DB) *TFIDFStore {
+	return &TFIDFStore{db: db}
+}
+
+func (s *TFIDFStore) Upsert(ctx context.Context, noteID int64, content string) error {
+	counts := termCounts(notetext.Plain(content))
+	if err := s.db.SaveNoteTerms(noteID, counts); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		s.remove(noteID)
+		s.terms[noteID] = counts
+		for term := range counts {
+			s.df[term]++
+		}
+	}
+	return nil
+}
+
+func (s *TFIDFStore) Delete(ctx context.Context, noteID int64) error {
+	if err := s.db.DeleteNoteTerms(noteID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		s.remove(noteID)
+	}
+	return nil
+}
+
+// remove drops a note from the in-memory index; the caller holds the lock
+func (s *TFIDFStore) remove(noteID int64) {
+	for term := range s.terms[noteID] {
+		s.df[term]--
+		if s.df[term] <= 0 {
+			delete(s.df, term)
+		}
+	}
+	delete(s.terms, noteID)
+}
+
+func (s *TFIDFStore) Similar(ctx context.Context, noteID int64, threshold float64, topK int) ([]Match, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts, ok := s.terms[noteID]
+	if !ok {
+		return nil, nil
+	}
+	matches := s.rank(counts, noteID, threshold)
+	if topK > 0 && len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
+
+func (s *TFIDFStore) Search(ctx context.Context, query string, threshold float64, topK int) ([]Match, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := s.rank(termCounts(query), 0, threshold)
+	if topK > 0 && len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
+
+// load reads every note's term counts into memory on first use
+func (s *TFIDFStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loaded {
+		return nil
+	}
+
+	terms, err := s.db.GetAllNoteTerms()
+	if err != nil {
+		return err
+	}
+
+	s.terms = terms
+	s.df = make(map[string]int)
+	for _, counts := range terms {
+		for term := range counts {
+			s.df[term]++
+		}
+	}
+	s.loaded = true
+	return nil
+}
+
+// rank scores every note other than exclude against the query term counts.
+// The caller holds the read lock.
+func (s *TFIDFStore) rank(query map[string]int, exclude int64, threshold float64) []Match {
+	queryVector, queryNorm := s.vector(query)
+	if queryNorm == 0 {
+		return nil
+	}
+
+	var matches []Match
+	for noteID, counts := range s.terms {
+		if noteID == exclude {
+			continue
+		}
+
+		docVector, docNorm := s.vector(counts)
+		if docNorm == 0 {
+			continue
+		}
+
+		var dot float64
+		for term, weight := range queryVector {
+			dot += weight * docVector[term]
+		}
+
+		score := dot / (queryNorm * docNorm)
+		if score > 0 && score >= threshold {
+			matches = append(matches, Match{ID: noteID, Score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+// vector weights term counts by sublinear TF and smoothed IDF and returns
+// the vector with its Euclidean norm
+func (s *TFIDFStore) vector(counts map[string]int) (map[string]float64, float64) {
+	n := float64(len(s.terms))
+	vector := make(map[string]float64, len(counts))
+	var norm float64
+	for term, count := range counts {
+		idf := math.Log((n+1)/(float64(s.df[term])+1)) + 1
+		weight := (1 + math.Log(float64(count))) * idf
+		vector[term] = weight
+		norm += weight * weight
+	}
+	return vector, math.Sqrt(norm)
+}
+
+// termCounts splits text into lowercase words and counts them, ignoring stop
+// words and single characters
+func termCounts(text string) map[string]int {
+	counts := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if len([]rune(word)) < 2 || stopWords[word] {
+			continue
+		}
+		counts[word]++
+	}
+	return counts
+}
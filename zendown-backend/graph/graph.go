@@ -0,0 +1,266 @@
+package graph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Edge kinds
+const (
+	KindLink       = "link"
+	KindCollection = "collection"
+	KindSimilarity = "similarity"
+)
+
+type Node struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+// Edge connects two notes. Link edges are directed from source to target;
+// collection and similarity edges are symmetric and always stored with
+// Source < Target.
+type Edge struct {
+	Source int64   `json:"source"`
+	Target int64   `json:"target"`
+	Kind   string  `json:"kind"`
+	Weight float64 `json:"weight"`
+}
+
+type Graph struct {
+	Nodes    []Node   `json:"nodes"`
+	Edges    []Edge   `json:"edges"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type edgeKey struct {
+	source, target int64
+	kind           string
+}
+
+// Builder accumulates nodes and edges, merging repeated edges by summing
+// their weights
+type Builder struct {
+	nodes     []Node
+	nodeIndex map[int64]bool
+	edges     []Edge
+	edgeIndex map[edgeKey]int
+	warnings  []string
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		nodeIndex: make(map[int64]bool),
+		edgeIndex: make(map[edgeKey]int),
+	}
+}
+
+func (b *Builder) AddNode(id int64, title string) {
+	if b.nodeIndex[id] {
+		return
+	}
+	b.nodeIndex[id] = true
+	b.nodes = append(b.nodes, Node{ID: id, Title: title})
+}
+
+func (b *Builder) HasNode(id int64) bool {
+	return b.nodeIndex[id]
+}
+
+// AddEdge adds weight to the edge between two known nodes. Self-loops and
+// edges touching unknown nodes are ignored.
+func (b *Builder) AddEdge(source, target int64, kind string, weight float64) {
+	if source == target || !b.nodeIndex[source] || !b.nodeIndex[target] {
+		return
+	}
+	if kind != KindLink && source > target {
+		source, target = target, source
+	}
+
+	key := edgeKey{source, target, kind}
+	if i, ok := b.edgeIndex[key]; ok {
+		b.edges[i].Weight += weight
+		return
+	}
+	b.edgeIndex[key] = len(b.edges)
+	b.edges = append(b.edges, Edge{Source: source, Target: target, Kind: kind, Weight: weight})
+}
+
+// MaxEdgeWeight raises the weight of an edge to weight if it is lower,
+// instead of accumulating it. Similarity scores are reported from both ends
+// of a pair, so the higher one is kept.
+func (b *Builder) MaxEdgeWeight(source, target int64, kind string, weight float64) {
+	if source > target {
+		source, target = target, source
+	}
+	if i, ok := b.edgeIndex[edgeKey{source, target, kind}]; ok {
+		if weight > b.edges[i].Weight {
+			b.edges[i].Weight = weight
+		}
+		return
+	}
+	b.AddEdge(source, target, kind, weight)
+}
+
+func (b *Builder) Warn(format string, args ...interface{}) {
+	b.warnings = append(b.warnings, fmt.Sprintf(format, args...))
+}
+
+func (b *Builder) Graph() *Graph {
+	return &Graph{
+		Nodes:    append([]Node{}, b.nodes...),
+		Edges:    append([]Edge{}, b.edges...),
+		Warnings: b.warnings,
+	}
+}
+
+// Neighborhood returns the subgraph of nodes within depth hops of focus,
+// following edges in both directions
+func (g *Graph) Neighborhood(focus int64, depth int) *Graph {
+	adjacent := make(map[int64][]int64)
+	for _, e := range g.Edges {
+		adjacent[e.Source] = append(adjacent[e.Source], e.Target)
+		adjacent[e.Target] = append(adjacent[e.Target], e.Source)
+	}
+
+	keep := map[int64]bool{focus: true}
+	frontier := []int64{focus}
+	for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+		var next []int64
+		for _, id := range frontier {
+			for _, neighbor := range adjacent[id] {
+				if !keep[neighbor] {
+					keep[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	sub := &Graph{Nodes: []Node{}, Edges: []Edge{}, Warnings: g.Warnings}
+	for _, n := range g.Nodes {
+		if keep[n.ID] {
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if keep[e.Source] && keep[e.Target] {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+	return sub
+}
+
+// WriteDOT renders the graph in Graphviz DOT format. Link edges point from
+// source to target; the other kinds have no direction.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph zendown {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  %d [label=%s];\n", n.ID, strconv.Quote(n.Title))
+	}
+	for _, e := range g.Edges {
+		style, dir := "solid", "forward"
+		switch e.Kind {
+		case KindCollection:
+			style, dir = "dotted", "none"
+		case KindSimilarity:
+			style, dir = "dashed", "none"
+		}
+		fmt.Fprintf(&b, "  %d -> %d [kind=%s, weight=%s, style=%s, dir=%s];\n",
+			e.Source, e.Target, e.Kind, strconv.FormatFloat(e.Weight, 'f', -1, 64), style, dir)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source   string        `xml:"source,attr"`
+	Target   string        `xml:"target,attr"`
+	Directed bool          `xml:"directed,attr"`
+	Data     []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// WriteGraphML renders the graph as GraphML for tools like Gephi or yEd
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "title", For: "node", AttrName: "title", AttrType: "string"},
+			{ID: "kind", For: "edge", AttrName: "kind", AttrType: "string"},
+			{ID: "weight", For: "edge", AttrName: "weight", AttrType: "double"},
+		},
+		Graph: graphMLGraph{ID: "zendown", EdgeDefault: "undirected"},
+	}
+
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID:   nodeID(n.ID),
+			Data: []graphMLData{{Key: "title", Value: n.Title}},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source:   nodeID(e.Source),
+			Target:   nodeID(e.Target),
+			Directed: e.Kind == KindLink,
+			Data: []graphMLData{
+				{Key: "kind", Value: e.Kind},
+				{Key: "weight", Value: strconv.FormatFloat(e.Weight, 'f', -1, 64)},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func nodeID(id int64) string {
+	return "n" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"zendown/database"
+	"zendown/search"
+)
+
+// ftsFallbackSearch answers a full-text query from the SQLite FTS index when
+// the Bleve index is unavailable. Filters are applied to the matches, and a
+// query without required or optional text lists the most recently updated
+// notes passing them and not excluded by a -clause.
+// It returns the page of up to limit results after offset and the number of
+// matches. Facets are counted over all matches when facetSize is positive.
+func (h *Handler) ftsFallbackSearch(parsed *search.Query, offset, limit int, includeContent bool, facetSize int) ([]FullTextSearchResponse, int, *search.Facets, error) {
+	var matches []*database.FTSResult
+	total := -1
+	if match := parsed.FTS5Match(); match != "" {
+		// Filters are applied and facets counted afterwards, so they need
+		// every match; otherwise SQLite can cut the page
+		var err error
+		if parsed.HasFilters() || facetSize > 0 {
+			matches, err = h.db.SearchNotesFTS(match, 0, 0)
+		} else {
+			matches, err = h.db.SearchNotesFTS(match, offset, limit)
+			if err == nil {
+				total, err = h.db.CountNotesFTS(match)
+			}
+			offset = 0
+		}
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	} else {
+		notes, err := h.db.GetAllNotes()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		// FTS5 cannot negate on its own, so drop the excluded notes here
+		excluded := map[int64]bool{}
+		if expr := parsed.FTS5Excluded(); expr != "" {
+			excluded, err = h.db.MatchingNotesFTS(expr)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+		}
+		for _, note := range notes {
+			if !excluded[note.ID] {
+				matches = append(matches, &database.FTSResult{Note: note})
+			}
+		}
+	}
+
+	var collections map[int64][]string
+	var faceted []search.IndexedNote
+	if facetSize > 0 {
+		var err error
+		collections, err = h.db.GetCollectionNamesByNote()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	results := []FullTextSearchResponse{}
+	passed := 0
+	for _, match := range matches {
+		if !parsed.Filter(match.Note) {
+			continue
+		}
+		passed++
+		if facetSize > 0 {
+			faceted = append(faceted, search.IndexedNote{Note: match.Note, Collections: collections[match.ID]})
+		}
+		if passed <= offset || len(results) == limit {
+			continue
+		}
+
+		note := match.Note
+		if !includeContent {
+			note.Content = ""
+		}
+
+		result := FullTextSearchResponse{Note: note, Score: match.Score}
+		if match.Snippet != "" {
+			result.Fragments = map[string][]string{"content": {match.Snippet}}
+		}
+		results = append(results, result)
+	}
+	if total < 0 {
+		total = passed
+	}
+
+	var facets *search.Facets
+	if facetSize > 0 {
+		facets = search.FacetsOf(faceted, facetSize)
+	}
+
+	return results, total, facets, nil
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zendown/database"
+	"zendown/embedding"
+	"zendown/graph"
+)
+
+// similarityCacheTTL bounds how long cached SemWare neighbours are trusted.
+// Entries are also dropped as soon as the source note changes.
+const similarityCacheTTL = 15 * time.Minute
+
+// similarityFetchConcurrency limits parallel SemWare calls on cache misses
+const similarityFetchConcurrency = 4
+
+var errSimilaritySkipped = errors.New("similarity lookup skipped")
+
+type similarityEntry struct {
+	updatedAt time.Time
+	threshold float64
+	topK      int
+	fetchedAt time.Time
+	results   []embedding.Match
+}
+
+// similarityCache keeps SemWare similar-document results per note so the
+// graph endpoint does not make one round-trip per note on every request
+type similarityCache struct {
+	mu      sync.Mutex
+	entries map[int64]similarityEntry
+}
+
+func newSimilarityCache() *similarityCache {
+	return &similarityCache{entries: make(map[int64]similarityEntry)}
+}
+
+// get returns the best topK cached results at or above threshold if the
+// entry is still valid. An entry fetched with a higher threshold or fewer
+// results than asked for may lack some, so it is a miss.
+func (c *similarityCache) get(note *database.Note, threshold float64, topK int) ([]embedding.Match, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[note.ID]
+	if !ok || !entry.updatedAt.Equal(note.UpdatedAt) || entry.threshold > threshold || entry.topK < topK || time.Since(entry.fetchedAt) > similarityCacheTTL {
+		return nil, false
+	}
+
+	var results []embedding.Match
+	for _, result := range entry.results {
+		if len(results) == topK {
+			break
+		}
+		if result.Score >= threshold {
+			results = append(results, result)
+		}
+	}
+	return results, true
+}
+
+func (c *similarityCache) put(note *database.Note, threshold float64, topK int, results []embedding.Match) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[note.ID] = similarityEntry{
+		updatedAt: note.UpdatedAt,
+		threshold: threshold,
+		topK:      topK,
+		fetchedAt: time.Now(),
+		results:   results,
+	}
+}
+
+// clear drops every entry
+func (c *similarityCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]similarityEntry)
+}
+
+func (c *similarityCache) invalidate(noteID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, noteID)
+}
+
+// GetGraph returns the note graph built from explicit links, shared
+// collections and semantic similarity.
+//
+// Query parameters:
+//   - collection: only include notes in this collection
+//   - focus, depth: only include notes within depth hops of the focus note
+//   - sources: comma-separated subset of link,collection,similarity
+//   - threshold: minimum similarity score for similarity edges
+//   - format: json (default), graphml or dot
+func (h *Handler) GetGraph(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "graphml" && format != "dot" {
+		http.Error(w, "Format must be one of json, graphml or dot", http.StatusBadRequest)
+		return
+	}
+
+	sources := map[string]bool{graph.KindLink: true, graph.KindCollection: true, graph.KindSimilarity: true}
+	if s := params.Get("sources"); s != "" {
+		sources = make(map[string]bool)
+		for _, source := range strings.Split(s, ",") {
+			source = strings.TrimSpace(source)
+			if source != graph.KindLink && source != graph.KindCollection && source != graph.KindSimilarity {
+				http.Error(w, "Unknown edge source: "+source, http.StatusBadRequest)
+				return
+			}
+			sources[source] = true
+		}
+	}
+
+	settings := h.semanticSettings()
+	threshold := semanticThreshold(r, settings)
+
+	var focus int64
+	if f := params.Get("focus"); f != "" {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid focus note ID", http.StatusBadRequest)
+			return
+		}
+		focus = id
+	}
+
+	depth := 1
+	if d := params.Get("depth"); d != "" {
+		v, err := strconv.Atoi(d)
+		if err != nil || v < 0 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+		depth = v
+	}
+
+	// Collect the notes that make up the graph
+	var notes []*database.Note
+	var err error
+	if c := params.Get("collection"); c != "" {
+		collectionID, parseErr := strconv.ParseInt(c, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+			return
+		}
+		notes, err = h.db.GetNotesByCollection(collectionID)
+	} else {
+		notes, err = h.db.GetAllNotes()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	builder := graph.NewBuilder()
+	for _, note := range notes {
+		builder.AddNode(note.ID, note.Title)
+	}
+
+	if focus != 0 && !builder.HasNode(focus) {
+		http.Error(w, "Focus note not found", http.StatusNotFound)
+		return
+	}
+
+	if sources[graph.KindLink] {
+		links, err := h.db.GetResolvedLinks()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, link := range links {
+			builder.AddEdge(link.SourceID, *link.TargetID, graph.KindLink, 1)
+		}
+	}
+
+	if sources[graph.KindCollection] {
+		memberships, err := h.db.GetAllNoteCollections()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		members := make(map[int64][]int64)
+		for _, m := range memberships {
+			members[m.CollectionID] = append(members[m.CollectionID], m.NoteID)
+		}
+		for _, noteIDs := range members {
+			for i := 0; i < len(noteIDs); i++ {
+				for j := i + 1; j < len(noteIDs); j++ {
+					builder.AddEdge(noteIDs[i], noteIDs[j], graph.KindCollection, 1)
+				}
+			}
+		}
+	}
+
+	if sources[graph.KindSimilarity] {
+		// With a focus note only its neighbourhood needs similarity lookups
+		similarityNotes := notes
+		if focus != 0 && depth <= 1 {
+			for _, note := range notes {
+				if note.ID == focus {
+					similarityNotes = []*database.Note{note}
+					break
+				}
+			}
+		}
+		h.addSimilarityEdges(r.Context(), builder, similarityNotes, threshold, settings.TopK)
+	}
+
+	g := builder.Graph()
+	if focus != 0 {
+		g = g.Neighborhood(focus, depth)
+	}
+
+	switch format {
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"zendown-graph.graphml\"")
+		if err := g.WriteGraphML(w); err != nil {
+			log.Printf("Failed to write GraphML: %v", err)
+		}
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"zendown-graph.dot\"")
+		if err := g.WriteDOT(w); err != nil {
+			log.Printf("Failed to write DOT graph: %v", err)
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g)
+	}
+}
+
+// addSimilarityEdges adds SemWare similarity edges for notes, using the
+// similarity cache and fetching misses with bounded concurrency
+func (h *Handler) addSimilarityEdges(ctx context.Context, builder *graph.Builder, notes []*database.Note, threshold float64, topK int) {
+	type fetched struct {
+		noteID  int64
+		results []embedding.Match
+		err     error
+	}
+
+	var misses []*database.Note
+	for _, note := range notes {
+		results, ok := h.similarity.get(note, threshold, topK)
+		if !ok {
+			misses = append(misses, note)
+			continue
+		}
+		addSimilarResults(builder, note.ID, results)
+	}
+
+	if len(misses) == 0 {
+		return
+	}
+
+	jobs := make(chan *database.Note)
+	out := make(chan fetched)
+	var wg sync.WaitGroup
+	// Once SemWare fails, skip the remaining lookups instead of waiting on each
+	var unavailable atomic.Bool
+	for i := 0; i < similarityFetchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for note := range jobs {
+				if unavailable.Load() {
+					out <- fetched{noteID: note.ID, err: errSimilaritySkipped}
+					continue
+				}
+				matches, err := h.semantic.Similar(ctx, note.ID, threshold, topK)
+				if err != nil {
+					unavailable.Store(true)
+					out <- fetched{noteID: note.ID, err: err}
+					continue
+				}
+				h.similarity.put(note, threshold, topK, matches)
+				out <- fetched{noteID: note.ID, results: matches}
+			}
+		}()
+	}
+
+	go func() {
+		for _, note := range misses {
+			jobs <- note
+		}
+		close(jobs)
+		wg.Wait()
+		close(out)
+	}()
+
+	failures := 0
+	for f := range out {
+		if f.err != nil {
+			failures++
+			if f.err != errSimilaritySkipped {
+				log.Printf("Failed to get similar documents for note %d: %v", f.noteID, f.err)
+			}
+			continue
+		}
+		addSimilarResults(builder, f.noteID, f.results)
+	}
+
+	if failures > 0 {
+		builder.Warn("similarity edges missing for %d notes: semantic search unavailable", failures)
+	}
+}
+
+func addSimilarResults(builder *graph.Builder, noteID int64, results []embedding.Match) {
+	for _, result := range results {
+		builder.MaxEdgeWeight(noteID, result.ID, graph.KindSimilarity, result.Score)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"archive/zip"
 	"bytes"
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,11 +15,15 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"zendown/database"
+	"zendown/embedding"
+	"zendown/outbox"
 	"zendown/search"
 	"zendown/semware"
 
@@ -30,15 +36,31 @@ import (
 )
 
 type Handler struct {
-	db      *database.DB
-	semware *semware.Client
-	bm25    *search.BM25SearchService
+	db              *database.DB
+	semanticBackend string
+	semantic        embedding.VectorStore
+	// semware and semwareStore are the SemWare client and vector store, or
+	// nil if the local backend is used
+	semware       *semware.Client
+	semwareStore  *embedding.SemWareStore
+	semwareStatus semwareStatus
+	// indexTargets are the indexes the configured backends use
+	indexTargets []string
+	bm25         *search.BM25SearchService
+	languages    search.LanguageConfig
+	similarity   *similarityCache
+	indexSync    *outbox.Worker
+	reconcile    reconcileState
+	reembedding  sync.Mutex
 }
 
-func NewHandler(db *database.DB) *Handler {
+// NewHandler wires the handlers to the database and search backends.
+// semanticBackend is one of the embedding.Backend* values and languages
+// selects the analyzers of the BM25 index.
+func NewHandler(db *database.DB, semanticBackend string, languages search.LanguageConfig) *Handler {
 	// Initialize BM25 search service
 	log.Printf("Initializing BM25 search service...")
-	bm25Service, err := search.NewBM25SearchService("data/bm25_index")
+	bm25Service, err := search.NewBM25SearchService("data/bm25_index", languages)
 	if err != nil {
 		log.Printf("Warning: Failed to initialize BM25 search service: %v", err)
 		bm25Service = nil
@@ -46,11 +68,58 @@ func NewHandler(db *database.DB) *Handler {
 		log.Printf("BM25 search service initialized successfully")
 	}
 
-	return &Handler{
-		db:      db,
-		semware: semware.NewClient(),
-		bm25:    bm25Service,
+	h := &Handler{
+		db:              db,
+		semanticBackend: semanticBackend,
+		bm25:            bm25Service,
+		languages:       languages,
+		similarity:      newSimilarityCache(),
+	}
+
+	// Index changes are queued in the database and applied by the sync worker
+	targets := make(map[string]outbox.Target)
+
+	semwareClient := semware.NewClient()
+	semwareStore := embedding.NewSemWareStore(semwareClient)
+	localStore := embedding.NewTFIDFStore(db)
+	switch semanticBackend {
+	case embedding.BackendSemWare:
+		h.semantic = semwareStore
+		h.semware = semwareClient
+		h.semwareStore = semwareStore
+	case embedding.BackendLocal:
+		h.semantic = localStore
+		targets[database.IndexTargetLocal] = vectorTarget{store: localStore}
+	default:
+		// Keep the local index up to date so it can answer while SemWare is down
+		h.semantic = &embedding.Fallback{Primary: semwareStore, Secondary: localStore}
+		h.semware = semwareClient
+		h.semwareStore = semwareStore
+		targets[database.IndexTargetLocal] = vectorTarget{store: localStore}
+	}
+	// Without an API key every SemWare job would fail, and pending jobs keep
+	// saved search counts from being cached, so SemWare is not synced at all
+	if h.semware != nil && semwareClient.Configured() {
+		targets[database.IndexTargetSemWare] = vectorTarget{store: semwareStore}
 	}
+	log.Printf("Using %s semantic backend", semanticBackend)
+	h.applySemanticSettings(h.semanticSettings())
+
+	if bm25Service != nil {
+		targets[database.IndexTargetBM25] = bm25Target{service: bm25Service, db: db}
+	}
+
+	// Only queue jobs for the semantic indexes in use. BM25 stays listed even
+	// when it failed to open so reconciliation reports it.
+	for _, target := range database.AllIndexTargets {
+		if _, ok := targets[target]; ok || target == database.IndexTargetBM25 {
+			h.indexTargets = append(h.indexTargets, target)
+		}
+	}
+
+	h.indexSync = outbox.NewWorker(db, targets, outbox.DefaultOptions())
+
+	return h
 }
 
 type CreateNoteRequest struct {
@@ -61,6 +130,8 @@ type CreateNoteRequest struct {
 type UpdateNoteRequest struct {
 	Title   string `json:"title"`
 	Content string `json:"content"`
+	// RewriteLinks updates [[Old Title]] links in other notes when the title changes
+	RewriteLinks bool `json:"rewrite_links,omitempty"`
 }
 
 type AddCollectionRequest struct {
@@ -72,9 +143,10 @@ type RemoveCollectionRequest struct {
 }
 
 type CreateAutoCollectionRequest struct {
-	CollectionName string  `json:"collection_name"`
-	Description    string  `json:"description"`
-	Threshold      float64 `json:"threshold"`
+	CollectionName string `json:"collection_name"`
+	Description    string `json:"description"`
+	// Threshold defaults to the default threshold of the semantic settings
+	Threshold *float64 `json:"threshold"`
 }
 
 type SyncAutoCollectionRequest struct {
@@ -98,21 +170,7 @@ func (h *Handler) CreateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Sync with SemWare
-	go func() {
-		if _, err := h.semware.UpsertDocument(strconv.FormatInt(note.ID, 10), note.Content); err != nil {
-			log.Printf("Failed to sync note %d with SemWare: %v", note.ID, err)
-		}
-	}()
-
-	// Sync with BM25 index
-	go func() {
-		if h.bm25 != nil {
-			if err := h.bm25.IndexNote(note); err != nil {
-				log.Printf("Failed to sync note %d with BM25 index: %v", note.ID, err)
-			}
-		}
-	}()
+	h.noteChanged(note.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -162,27 +220,27 @@ func (h *Handler) UpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	previous, err := h.db.GetNote(id)
+	if err != nil {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
 	note, err := h.db.UpdateNote(id, req.Title, req.Content)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Sync with SemWare
-	go func() {
-		if _, err := h.semware.UpsertDocument(strconv.FormatInt(note.ID, 10), note.Content); err != nil {
-			log.Printf("Failed to sync note %d with SemWare: %v", note.ID, err)
-		}
-	}()
+	h.noteChanged(note.ID)
 
-	// Sync with BM25 index
-	go func() {
-		if h.bm25 != nil {
-			if err := h.bm25.IndexNote(note); err != nil {
-				log.Printf("Failed to sync note %d with BM25 index: %v", note.ID, err)
-			}
-		}
-	}()
+	if req.RewriteLinks && previous.Title != note.Title {
+		h.rewriteLinksTo(note.ID, previous.Title, note.Title)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(note)
@@ -196,30 +254,37 @@ func (h *Handler) DeleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Move the note to the trash; it can be restored until it is purged
 	if err := h.db.DeleteNote(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Delete from SemWare
-	go func() {
-		if err := h.semware.DeleteDocument(strconv.FormatInt(id, 10)); err != nil {
-			log.Printf("Failed to delete note %d from SemWare: %v", id, err)
-		}
-	}()
-
-	// Delete from BM25 index
-	go func() {
-		if h.bm25 != nil {
-			if err := h.bm25.RemoveNote(id); err != nil {
-				log.Printf("Failed to delete note %d from BM25 index: %v", id, err)
-			}
-		}
-	}()
+	h.noteChanged(id)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// noteChanged is called after a note was written. The database has already
+// queued the index updates in the outbox; this drops cached similarity
+// results and wakes the sync worker so the change is picked up right away.
+func (h *Handler) noteChanged(noteID int64) {
+	h.similarity.invalidate(noteID)
+	h.indexSync.Notify()
+}
+
+// SearchNotesResponse is a page of notes matching a quick search
+type SearchNotesResponse struct {
+	Results []*database.FTSResult `json:"results"`
+	Page
+}
+
+// SearchNotes finds notes containing every typed word as a word prefix. It
+// takes limit and offset or cursor; see parsePage.
 func (h *Handler) SearchNotes(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
@@ -227,23 +292,37 @@ func (h *Handler) SearchNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	notes, err := h.db.SearchNotes(query)
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notes, total, err := h.db.SearchNotes(query, offset, limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notes)
+	json.NewEncoder(w).Encode(SearchNotesResponse{
+		Results: notes,
+		Page:    newPage(offset, limit, total),
+	})
 }
 
-// RelatedNoteResponse represents a note with its similarity score
+// RelatedNoteResponse represents a note with its similarity score and, when
+// the semantic backend reports them, the passages of the note that matched
 type RelatedNoteResponse struct {
-	Note  *database.Note `json:"note"`
-	Score float64        `json:"score"`
+	Note     *database.Note      `json:"note"`
+	Score    float64             `json:"score"`
+	Passages []embedding.Passage `json:"passages,omitempty"`
 }
 
-// GetRelatedNotes returns related notes for a given note ID
+// GetRelatedNotes returns related notes for a given note ID. mode selects
+// the backend: semantic asks the semantic backend, lexical runs a "more like
+// this" query on the BM25 index, and auto (the default) tries semantic first
+// and falls back to lexical. The mode used is reported in X-Related-Mode.
 func (h *Handler) GetRelatedNotes(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
@@ -252,60 +331,124 @@ func (h *Handler) GetRelatedNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get query parameters
-	thresholdStr := r.URL.Query().Get("threshold")
+	settings := h.semanticSettings()
+	threshold := semanticThreshold(r, settings)
 
-	threshold := 0.3 // default threshold
-	if thresholdStr != "" {
-		if t, err := strconv.ParseFloat(thresholdStr, 64); err == nil {
-			threshold = t
-		}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = relatedModeAuto
 	}
-
-	// Get similar documents from SemWare
-	semwareResponse, err := h.semware.GetSimilarDocuments(strconv.FormatInt(id, 10), threshold)
-	if err != nil {
-		log.Printf("Failed to get similar documents for note %d: %v", id, err)
-		http.Error(w, "Failed to get related notes", http.StatusInternalServerError)
+	if mode != relatedModeAuto && mode != relatedModeSemantic && mode != relatedModeLexical {
+		http.Error(w, "Mode must be lexical, semantic or auto", http.StatusBadRequest)
 		return
 	}
 
-	// Get the actual note objects for the related note IDs
-	var relatedNotes []RelatedNoteResponse
-	for _, result := range semwareResponse.SimilarResults {
-		noteID, err := strconv.ParseInt(result.ID, 10, 64)
+	var matches []embedding.Match
+	if mode != relatedModeLexical {
+		// Get similar documents from the semantic backend
+		matches, err = h.semantic.Similar(r.Context(), id, threshold, settings.TopK)
 		if err != nil {
-			continue
+			log.Printf("Failed to get similar documents for note %d: %v", id, err)
+			if mode == relatedModeSemantic {
+				writeSemanticError(w, "Failed to get related notes", err)
+				return
+			}
+			log.Printf("Falling back to lexical related notes for note %d", id)
+		} else {
+			mode = relatedModeSemantic
+		}
+	}
+
+	if mode != relatedModeSemantic {
+		note, err := h.db.GetNote(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		note, err := h.db.GetNote(noteID)
+		matches, err = h.lexicalRelated(note, threshold, settings.TopK)
+		if err != nil {
+			log.Printf("Failed to get lexical related notes for note %d: %v", id, err)
+			http.Error(w, "Related notes are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		mode = relatedModeLexical
+	}
+
+	// Get the actual note objects for the related note IDs
+	var relatedNotes []RelatedNoteResponse
+	for _, result := range matches {
+		note, err := h.db.GetNote(result.ID)
 		if err != nil {
 			continue // Skip if note not found
 		}
 
 		relatedNotes = append(relatedNotes, RelatedNoteResponse{
-			Note:  note,
-			Score: result.Score,
+			Note:     note,
+			Score:    result.Score,
+			Passages: notePassages(note.Content, result.Passages),
 		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Related-Mode", mode)
 	json.NewEncoder(w).Encode(relatedNotes)
 }
 
-// SemanticSearchResponse represents a note with its similarity score from semantic search
+// SemanticSearchResponse represents a note with its similarity score from
+// semantic search and, when the backend reports them, the passages that
+// matched so the editor can jump to them
 type SemanticSearchResponse struct {
-	Note  *database.Note `json:"note"`
-	Score float64        `json:"score"`
+	Note     *database.Note      `json:"note"`
+	Score    float64             `json:"score"`
+	Passages []embedding.Passage `json:"passages,omitempty"`
 }
 
-// FullTextSearchResponse represents a note with its BM25 score from full-text search
+// FullTextSearchResponse represents a note with its BM25 score from full-text
+// search, with highlighted fragments and match locations per field
 type FullTextSearchResponse struct {
-	Note  *database.Note `json:"note"`
-	Score float64        `json:"score"`
+	Note      *database.Note                   `json:"note"`
+	Score     float64                          `json:"score"`
+	Fragments map[string][]string              `json:"fragments,omitempty"`
+	Locations map[string][]search.TermLocation `json:"locations,omitempty"`
+}
+
+// defaultFacetSize is the number of buckets per facet unless facet_size is given
+const defaultFacetSize = 10
+
+// FullTextSearchPage is a page of full-text search results, with the facets
+// of all matches when they are requested
+type FullTextSearchPage struct {
+	Results []FullTextSearchResponse `json:"results"`
+	Page
+	Facets *search.Facets `json:"facets,omitempty"`
+}
+
+// SemanticSearchPage is a page of semantic search results
+type SemanticSearchPage struct {
+	Results []SemanticSearchResponse `json:"results"`
+	Page
+}
+
+// writeSemanticError responds with 503 when the semantic backend is
+// unavailable, so clients can tell an outage from a failed request, and with
+// 500 and message otherwise
+func writeSemanticError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, semware.ErrUnavailable) {
+		http.Error(w, "Semantic features unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
 }
 
-// SemanticSearch performs semantic search across all notes
+// SemanticSearch performs semantic search across all notes. The top_k
+// nearest notes above the threshold are fetched and paged through with limit
+// and offset or cursor; both default to the semantic settings, and totals
+// count at most top_k matches.
 func (h *Handler) SemanticSearch(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	query := r.URL.Query().Get("q")
@@ -317,57 +460,66 @@ func (h *Handler) SemanticSearch(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("SemanticSearch: Query='%s'", query)
 
-	thresholdStr := r.URL.Query().Get("threshold")
+	settings := h.semanticSettings()
+	threshold := semanticThreshold(r, settings)
 
-	threshold := 0.3 // default threshold (30% similarity)
-	if thresholdStr != "" {
-		if t, err := strconv.ParseFloat(thresholdStr, 64); err == nil {
-			threshold = t
-		}
+	topK := settings.TopK
+	if k, err := strconv.Atoi(r.URL.Query().Get("top_k")); err == nil && k > 0 {
+		topK = k
+	}
+
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	log.Printf("SemanticSearch: threshold=%f", threshold)
+	log.Printf("SemanticSearch: threshold=%f, top_k=%d", threshold, topK)
 
-	// Perform semantic search using SemWare
-	semwareResponse, err := h.semware.SemanticSearch(query, threshold)
+	// Perform semantic search using the semantic backend
+	matches, err := h.semantic.Search(r.Context(), query, threshold, topK)
 	if err != nil {
-		log.Printf("SemanticSearch: SemWare error: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to perform semantic search: %v", err), http.StatusInternalServerError)
+		log.Printf("SemanticSearch: backend error: %v", err)
+		writeSemanticError(w, fmt.Sprintf("Failed to perform semantic search: %v", err), err)
 		return
 	}
 
-	log.Printf("SemanticSearch: SemWare returned %d results", len(semwareResponse.SimilarResults))
+	log.Printf("SemanticSearch: backend returned %d results", len(matches))
 
-	// Convert SemWare results to our response format
-	var searchResults []SemanticSearchResponse
-	for _, result := range semwareResponse.SimilarResults {
-		// Convert document ID back to note ID
-		noteID, err := strconv.ParseInt(result.ID, 10, 64)
-		if err != nil {
-			log.Printf("SemanticSearch: Failed to parse note ID %s: %v", result.ID, err)
-			continue
-		}
+	// Pages are cut from the ranking, so it must not depend on the backend
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
 
+	// Convert backend results to our response format
+	searchResults := []SemanticSearchResponse{}
+	for _, result := range matches {
 		// Get the note from database
-		note, err := h.db.GetNote(noteID)
+		note, err := h.db.GetNote(result.ID)
 		if err != nil {
-			log.Printf("SemanticSearch: Failed to get note %d: %v", noteID, err)
+			log.Printf("SemanticSearch: Failed to get note %d: %v", result.ID, err)
 			continue
 		}
 
 		searchResults = append(searchResults, SemanticSearchResponse{
-			Note:  note,
-			Score: result.Score,
+			Note:     note,
+			Score:    result.Score,
+			Passages: notePassages(note.Content, result.Passages),
 		})
 	}
 
 	log.Printf("SemanticSearch: Returning %d results", len(searchResults))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(searchResults)
+	json.NewEncoder(w).Encode(SemanticSearchPage{
+		Results: pageOf(searchResults, offset, limit),
+		Page:    newPage(offset, limit, len(searchResults)),
+	})
 }
 
-// FullTextSearch performs BM25 full-text search across all notes
+// FullTextSearch performs BM25 full-text search across all notes. The query
+// supports fields, phrases, +/- operators and date and collection filters
+// (see search.Query). Results carry highlighted fragments; pass
+// include_content=true for full note bodies. Pages are selected with limit
+// and offset or cursor.
 func (h *Handler) FullTextSearch(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	query := r.URL.Query().Get("q")
@@ -379,48 +531,88 @@ func (h *Handler) FullTextSearch(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("FullTextSearch: Query='%s'", query)
 
-	limitStr := r.URL.Query().Get("limit")
-	limit := 20 // default limit
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Check if BM25 service is available
-	if h.bm25 == nil {
-		log.Printf("FullTextSearch: BM25 service not available")
-		http.Error(w, "Full-text search service not available", http.StatusInternalServerError)
+	parsed, ok := h.parseSearchQuery(w, query)
+	if !ok {
 		return
 	}
 
-	log.Printf("FullTextSearch: Performing search with limit=%d", limit)
+	// Note bodies are only sent when asked for; fragments cover the usual case
+	includeContent := r.URL.Query().Get("include_content") == "true"
 
-	// Perform BM25 search
-	searchResults, err := h.bm25.Search(query, limit)
+	facetSize := 0
+	if r.URL.Query().Get("facets") == "true" {
+		facetSize = defaultFacetSize
+		if n, err := strconv.Atoi(r.URL.Query().Get("facet_size")); err == nil && n > 0 {
+			facetSize = n
+		}
+	}
+
+	// Without a usable Bleve index, degrade to the SQLite FTS index
+	var searchResults *search.SearchResults
+	err = search.ErrIndexUnavailable
+	if h.bm25 != nil {
+		log.Printf("FullTextSearch: Performing search with offset=%d, limit=%d", offset, limit)
+		searchResults, err = h.bm25.Search(parsed, search.SearchOptions{Limit: limit, Offset: offset, FacetSize: facetSize})
+	}
+	if errors.Is(err, search.ErrIndexUnavailable) {
+		log.Printf("FullTextSearch: BM25 index not available, falling back to SQLite FTS")
+		results, total, facets, err := h.ftsFallbackSearch(parsed, offset, limit, includeContent, facetSize)
+		if err != nil {
+			log.Printf("FullTextSearch: FTS search error: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to perform full-text search: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Search-Backend", "fts5")
+		writeFullTextResults(w, results, newPage(offset, limit, total), facets)
+		return
+	}
 	if err != nil {
 		log.Printf("FullTextSearch: BM25 search error: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to perform full-text search: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("FullTextSearch: BM25 returned %d raw results", len(searchResults))
+	log.Printf("FullTextSearch: BM25 returned %d raw results", len(searchResults.Hits))
 
 	// Convert to response format
-	var results []FullTextSearchResponse
-	for _, result := range searchResults {
-		if result.Note != nil {
-			results = append(results, FullTextSearchResponse{
-				Note:  result.Note,
-				Score: result.Score,
-			})
+	results := []FullTextSearchResponse{}
+	for _, result := range searchResults.Hits {
+		if result.Note == nil {
+			continue
+		}
+
+		note := result.Note
+		if includeContent {
+			full, err := h.db.GetNote(note.ID)
+			if err != nil {
+				continue // Skip notes deleted since they were indexed
+			}
+			note = full
 		}
+
+		results = append(results, FullTextSearchResponse{
+			Note:      note,
+			Score:     result.Score,
+			Fragments: result.Fragments,
+			Locations: result.Locations,
+		})
 	}
 
 	log.Printf("FullTextSearch: Returning %d valid results", len(results))
 
+	writeFullTextResults(w, results, newPage(offset, limit, int(searchResults.Total)), searchResults.Facets)
+}
+
+func writeFullTextResults(w http.ResponseWriter, results []FullTextSearchResponse, page Page, facets *search.Facets) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(FullTextSearchPage{Results: results, Page: page, Facets: facets})
 }
 
 // Supported image MIME types
@@ -736,6 +928,9 @@ func (h *Handler) AddNoteToCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The BM25 index stores collection names
+	h.indexSync.Notify()
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(collection)
 }
@@ -772,6 +967,9 @@ func (h *Handler) RemoveNoteFromCollection(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// The BM25 index stores collection names
+	h.indexSync.Notify()
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -793,21 +991,26 @@ func (h *Handler) CreateAutoCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate threshold
-	if req.Threshold < 0 || req.Threshold > 1 {
+	// Validate threshold, defaulting to the semantic settings
+	settings := h.semanticSettings()
+	threshold := settings.DefaultThreshold
+	if req.Threshold != nil {
+		threshold = *req.Threshold
+	}
+	if threshold < 0 || threshold > 1 {
 		http.Error(w, "Threshold must be between 0 and 1", http.StatusBadRequest)
 		return
 	}
 
 	// Create the auto-collection
-	collection, err := h.db.CreateAutoCollection(req.CollectionName, req.Description, req.Threshold, true)
+	collection, err := h.db.CreateAutoCollection(req.CollectionName, req.Description, threshold, true)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Perform semantic search to find similar notes
-	semwareResponse, err := h.semware.SemanticSearch(req.Description, req.Threshold)
+	matches, err := h.semantic.Search(r.Context(), req.Description, threshold, settings.TopK)
 	if err != nil {
 		log.Printf("Failed to perform semantic search for auto-collection %s: %v", req.CollectionName, err)
 		// Don't fail the request, just return the collection without notes
@@ -819,18 +1022,13 @@ func (h *Handler) CreateAutoCollection(w http.ResponseWriter, r *http.Request) {
 
 	// Add notes to the collection
 	var noteIDs []int64
-	for _, result := range semwareResponse.SimilarResults {
-		noteID, err := strconv.ParseInt(result.ID, 10, 64)
-		if err != nil {
-			continue
-		}
-
+	for _, result := range matches {
 		// Verify the note exists
-		if _, err := h.db.GetNote(noteID); err != nil {
+		if _, err := h.db.GetNote(result.ID); err != nil {
 			continue
 		}
 
-		noteIDs = append(noteIDs, noteID)
+		noteIDs = append(noteIDs, result.ID)
 	}
 
 	// Sync the collection with the found notes
@@ -838,6 +1036,7 @@ func (h *Handler) CreateAutoCollection(w http.ResponseWriter, r *http.Request) {
 		if err := h.db.SyncAutoCollection(collection.ID, noteIDs); err != nil {
 			log.Printf("Failed to sync auto-collection %s: %v", req.CollectionName, err)
 		}
+		h.indexSync.Notify()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -867,27 +1066,22 @@ func (h *Handler) SyncAutoCollection(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Perform semantic search to find similar notes
-	semwareResponse, err := h.semware.SemanticSearch(collection.Description, collection.Threshold)
+	matches, err := h.semantic.Search(r.Context(), collection.Description, collection.Threshold, h.semanticSettings().TopK)
 	if err != nil {
 		log.Printf("Failed to perform semantic search for auto-collection %s: %v", collection.Name, err)
-		http.Error(w, "Failed to perform semantic search", http.StatusInternalServerError)
+		writeSemanticError(w, "Failed to perform semantic search", err)
 		return
 	}
 
 	// Get note IDs from search results
 	var noteIDs []int64
-	for _, result := range semwareResponse.SimilarResults {
-		noteID, err := strconv.ParseInt(result.ID, 10, 64)
-		if err != nil {
-			continue
-		}
-
+	for _, result := range matches {
 		// Verify the note exists
-		if _, err := h.db.GetNote(noteID); err != nil {
+		if _, err := h.db.GetNote(result.ID); err != nil {
 			continue
 		}
 
-		noteIDs = append(noteIDs, noteID)
+		noteIDs = append(noteIDs, result.ID)
 	}
 
 	// Sync the collection with the found notes
@@ -897,6 +1091,8 @@ func (h *Handler) SyncAutoCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.indexSync.Notify()
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -908,14 +1104,53 @@ func (h *Handler) SetupRoutes(router *mux.Router) {
 	api.HandleFunc("/notes/search", h.SearchNotes).Methods("GET")
 	api.HandleFunc("/notes/semantic-search", h.SemanticSearch).Methods("GET")
 	api.HandleFunc("/notes/fulltext-search", h.FullTextSearch).Methods("GET")
+	api.HandleFunc("/notes/hybrid-search", h.HybridSearch).Methods("GET")
+	api.HandleFunc("/notes/suggest", h.SuggestNotes).Methods("GET")
 	api.HandleFunc("/notes/export-all", h.ExportAllNotesAsZip).Methods("GET")
 	api.HandleFunc("/notes/{id}", h.GetNote).Methods("GET")
 	api.HandleFunc("/notes/{id}", h.UpdateNote).Methods("PUT")
 	api.HandleFunc("/notes/{id}", h.DeleteNote).Methods("DELETE")
 	api.HandleFunc("/notes/{id}/related", h.GetRelatedNotes).Methods("GET")
+	api.HandleFunc("/notes/{id}/language", h.SetNoteLanguage).Methods("PUT")
 	api.HandleFunc("/notes/{id}/export", h.ExportNoteAsMarkdown).Methods("GET")
 	api.HandleFunc("/notes/{id}/export-raw", h.ExportNoteAsRawHTML).Methods("GET")
 
+	// Link routes
+	api.HandleFunc("/notes/{id}/backlinks", h.GetBacklinks).Methods("GET")
+	api.HandleFunc("/notes/{id}/outlinks", h.GetOutlinks).Methods("GET")
+	api.HandleFunc("/links/unresolved", h.GetUnresolvedLinks).Methods("GET")
+
+	// Graph routes
+	api.HandleFunc("/graph", h.GetGraph).Methods("GET")
+
+	// Status routes
+	api.HandleFunc("/status", h.GetStatus).Methods("GET")
+
+	// Settings routes
+	api.HandleFunc("/settings/semantic", h.GetSemanticSettings).Methods("GET")
+	api.HandleFunc("/settings/semantic", h.UpdateSemanticSettings).Methods("PUT")
+
+	// Admin routes
+	api.HandleFunc("/admin/sync-status", h.GetSyncStatus).Methods("GET")
+	api.HandleFunc("/admin/sync-retry", h.RetryFailedSync).Methods("POST")
+	api.HandleFunc("/admin/reconcile", h.GetReconcileReport).Methods("GET")
+	api.HandleFunc("/admin/reconcile", h.RunReconcile).Methods("POST")
+	api.HandleFunc("/admin/bm25/rebuild", h.GetBM25Rebuild).Methods("GET")
+	api.HandleFunc("/admin/bm25/rebuild", h.RebuildBM25).Methods("POST")
+	api.HandleFunc("/admin/reembed", h.Reembed).Methods("POST")
+
+	// Trash routes
+	api.HandleFunc("/trash", h.GetTrash).Methods("GET")
+	api.HandleFunc("/trash", h.EmptyTrash).Methods("DELETE")
+	api.HandleFunc("/trash/{id}/restore", h.RestoreNote).Methods("POST")
+	api.HandleFunc("/trash/{id}", h.DeleteNotePermanently).Methods("DELETE")
+
+	// Revision routes
+	api.HandleFunc("/notes/{id}/revisions", h.GetNoteRevisions).Methods("GET")
+	api.HandleFunc("/notes/{id}/revisions/diff", h.DiffNoteRevisions).Methods("GET")
+	api.HandleFunc("/notes/{id}/revisions/{rev:[0-9]+}", h.GetNoteRevision).Methods("GET")
+	api.HandleFunc("/notes/{id}/revisions/{rev:[0-9]+}/restore", h.RestoreNoteRevision).Methods("POST")
+
 	// Attachment routes
 	api.HandleFunc("/attachments/upload", h.UploadAttachment).Methods("POST")
 	api.HandleFunc("/attachments/all", h.GetAllAttachments).Methods("GET")
@@ -930,41 +1165,14 @@ func (h *Handler) SetupRoutes(router *mux.Router) {
 	api.HandleFunc("/notes/{id}/collections", h.RemoveNoteFromCollection).Methods("DELETE")
 	api.HandleFunc("/collections/auto", h.CreateAutoCollection).Methods("POST")
 	api.HandleFunc("/collections/auto/{id}", h.SyncAutoCollection).Methods("PUT")
-}
-
-// RebuildBM25Index rebuilds the BM25 search index from all notes in the database
-func (h *Handler) RebuildBM25Index() error {
-	if h.bm25 == nil {
-		return fmt.Errorf("BM25 service not available")
-	}
 
-	log.Printf("Starting BM25 index rebuild...")
-
-	// Get all notes from database
-	notes, err := h.db.GetAllNotes()
-	if err != nil {
-		return fmt.Errorf("failed to get notes for index rebuild: %w", err)
-	}
-
-	log.Printf("Found %d notes to index", len(notes))
-
-	// Index each note
-	for _, note := range notes {
-		if err := h.bm25.IndexNote(note); err != nil {
-			log.Printf("Failed to index note %d: %v", note.ID, err)
-			// Continue with other notes
-		}
-	}
-
-	// Get final document count
-	count, err := h.bm25.GetDocumentCount()
-	if err != nil {
-		log.Printf("Failed to get document count: %v", err)
-	} else {
-		log.Printf("BM25 index rebuild complete. Indexed %d documents", count)
-	}
-
-	return nil
+	// Saved search routes
+	api.HandleFunc("/saved-searches", h.GetSavedSearches).Methods("GET")
+	api.HandleFunc("/saved-searches", h.CreateSavedSearch).Methods("POST")
+	api.HandleFunc("/saved-searches/{id}", h.GetSavedSearch).Methods("GET")
+	api.HandleFunc("/saved-searches/{id}", h.UpdateSavedSearch).Methods("PUT")
+	api.HandleFunc("/saved-searches/{id}", h.DeleteSavedSearch).Methods("DELETE")
+	api.HandleFunc("/saved-searches/{id}/results", h.GetSavedSearchResults).Methods("GET")
 }
 
 // CalloutPlugin handles the conversion of callout divs to markdown
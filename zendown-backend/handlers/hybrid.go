@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"zendown/database"
+	"zendown/search"
+)
+
+// Backend names reported in hybrid search results
+const (
+	hybridBackendBM25     = "bm25"
+	hybridBackendSemantic = "semantic"
+)
+
+var hybridBackends = []string{hybridBackendBM25, hybridBackendSemantic}
+
+// HybridSearchHit is a note in the fused ranking. Sources names the backends
+// that returned it; Ranks and Scores hold each backend's rank and raw score.
+type HybridSearchHit struct {
+	Note    *database.Note     `json:"note"`
+	Score   float64            `json:"score"`
+	Sources []string           `json:"sources"`
+	Ranks   map[string]int     `json:"ranks"`
+	Scores  map[string]float64 `json:"scores"`
+}
+
+// HybridBackendStatus tells whether a backend contributed to the results
+type HybridBackendStatus struct {
+	Available bool   `json:"available"`
+	Hits      int    `json:"hits"`
+	Error     string `json:"error,omitempty"`
+}
+
+type HybridSearchResponse struct {
+	Fusion   string                         `json:"fusion"`
+	Results  []HybridSearchHit              `json:"results"`
+	Backends map[string]HybridBackendStatus `json:"backends"`
+}
+
+// HybridSearch runs BM25 and semantic search in parallel and fuses the
+// rankings. If one backend fails the other one's ranking is returned alone.
+// The query uses the full-text syntax; the semantic backend gets its words
+// and phrases, and the filters apply to both.
+//
+// Query parameters:
+//   - q: the search query (required), see search.Query
+//   - limit: maximum number of results (default 20)
+//   - fusion: rrf (default) or weighted
+//   - semantic_weight: share of the semantic ranking, 0 to 1 (default 0.5)
+//   - k: reciprocal rank fusion constant (default 60)
+//   - threshold: minimum semantic similarity (default from the semantic
+//     settings)
+func (h *Handler) HybridSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query := params.Get("q")
+	if query == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20 // default limit
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	fusion := params.Get("fusion")
+	if fusion == "" {
+		fusion = search.FusionRRF
+	}
+	if fusion != search.FusionRRF && fusion != search.FusionWeighted {
+		http.Error(w, "Fusion must be rrf or weighted", http.StatusBadRequest)
+		return
+	}
+
+	semanticWeight := 0.5
+	if s := params.Get("semantic_weight"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > 1 {
+			http.Error(w, "semantic_weight must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		semanticWeight = v
+	}
+
+	k := float64(search.DefaultRRFK)
+	if s := params.Get("k"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			http.Error(w, "k must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		k = v
+	}
+
+	threshold := semanticThreshold(r, h.semanticSettings())
+
+	parsed, ok := h.parseSearchQuery(w, query)
+	if !ok {
+		return
+	}
+
+	response, err := h.hybridSearch(r.Context(), parsed, hybridOptions{
+		Limit:          limit,
+		Fusion:         fusion,
+		SemanticWeight: semanticWeight,
+		K:              k,
+		Threshold:      threshold,
+	})
+	if err != nil {
+		http.Error(w, "Both search backends are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// hybridOptions are the tuning parameters of a hybrid search
+type hybridOptions struct {
+	Limit          int
+	Fusion         string
+	SemanticWeight float64
+	K              float64
+	Threshold      float64
+}
+
+// defaultHybridOptions match the defaults of the hybrid search endpoint
+func defaultHybridOptions(limit int, threshold float64) hybridOptions {
+	return hybridOptions{
+		Limit:          limit,
+		Fusion:         search.FusionRRF,
+		SemanticWeight: 0.5,
+		K:              search.DefaultRRFK,
+		Threshold:      threshold,
+	}
+}
+
+var errHybridUnavailable = errors.New("both search backends are unavailable")
+
+// hybridSearch runs both backends for parsed and fuses their rankings. It
+// fails with errHybridUnavailable only if both backends failed.
+func (h *Handler) hybridSearch(ctx context.Context, parsed *search.Query, opts hybridOptions) (*HybridSearchResponse, error) {
+	limit, fusion, threshold := opts.Limit, opts.Fusion, opts.Threshold
+
+	// Fetch more candidates than requested so fusion has overlap to work with
+	candidates := limit * 3
+
+	rankings := make(map[string][]search.RankedHit)
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		hits, err := h.bm25Ranking(parsed, candidates)
+		mu.Lock()
+		rankings[hybridBackendBM25], errs[hybridBackendBM25] = hits, err
+		mu.Unlock()
+	}()
+	go func() {
+		defer wg.Done()
+		hits, err := h.semanticRanking(ctx, parsed.Text(), threshold, candidates)
+		mu.Lock()
+		rankings[hybridBackendSemantic], errs[hybridBackendSemantic] = hits, err
+		mu.Unlock()
+	}()
+	wg.Wait()
+
+	response := HybridSearchResponse{
+		Fusion:   fusion,
+		Results:  []HybridSearchHit{},
+		Backends: make(map[string]HybridBackendStatus),
+	}
+	failed := 0
+	for _, backend := range hybridBackends {
+		status := HybridBackendStatus{Available: errs[backend] == nil, Hits: len(rankings[backend])}
+		if err := errs[backend]; err != nil {
+			log.Printf("HybridSearch: %s backend failed: %v", backend, err)
+			status.Error = err.Error()
+			failed++
+		}
+		response.Backends[backend] = status
+	}
+
+	if failed == len(hybridBackends) {
+		return nil, errHybridUnavailable
+	}
+
+	weights := hybridWeights(map[string]float64{
+		hybridBackendBM25:     1 - opts.SemanticWeight,
+		hybridBackendSemantic: opts.SemanticWeight,
+	}, errs)
+
+	var fused []search.FusedHit
+	if fusion == search.FusionWeighted {
+		fused = search.FuseWeighted(hybridBackends, rankings, weights)
+	} else {
+		fused = search.FuseRRF(hybridBackends, rankings, weights, opts.K)
+	}
+
+	// The semantic backend only saw the words, so notes it found alone have
+	// not been checked against +required and -excluded words yet
+	var passing map[int64]bool
+	if constraints := parsed.Constraints(); constraints != nil {
+		var semanticOnly []int64
+		for _, hit := range fused {
+			if !slices.Contains(hit.Sources, hybridBackendBM25) {
+				semanticOnly = append(semanticOnly, hit.NoteID)
+			}
+		}
+		if len(semanticOnly) > 0 {
+			var err error
+			passing, err = h.matchingNotes(constraints, semanticOnly)
+			if err != nil {
+				log.Printf("HybridSearch: failed to check semantic hits against the query, dropping them: %v", err)
+				passing = map[int64]bool{}
+			}
+		}
+	}
+
+	for _, hit := range fused {
+		if len(response.Results) == limit {
+			break
+		}
+		if passing != nil && !slices.Contains(hit.Sources, hybridBackendBM25) && !passing[hit.NoteID] {
+			continue
+		}
+
+		// The indexes may briefly lag behind deletions, so skip notes that are gone
+		note, err := h.db.GetNote(hit.NoteID)
+		if err != nil {
+			continue
+		}
+
+		// Semantic hits have not seen the date and collection filters yet
+		if !parsed.Filter(note) {
+			continue
+		}
+
+		response.Results = append(response.Results, HybridSearchHit{
+			Note:    note,
+			Score:   hit.Score,
+			Sources: hit.Sources,
+			Ranks:   hit.Ranks,
+			Scores:  hit.Scores,
+		})
+	}
+
+	return &response, nil
+}
+
+// hybridWeights spreads the weights over the backends that answered, so a
+// failed backend's share goes to the others. If the backends that answered
+// were given no weight at all, they share it equally.
+func hybridWeights(requested map[string]float64, errs map[string]error) map[string]float64 {
+	weights := make(map[string]float64)
+	total, answered := 0.0, 0
+	for backend, weight := range requested {
+		if errs[backend] != nil {
+			continue
+		}
+		weights[backend] = weight
+		total += weight
+		answered++
+	}
+
+	for backend := range weights {
+		if total > 0 {
+			weights[backend] /= total
+		} else {
+			weights[backend] = 1 / float64(answered)
+		}
+	}
+	return weights
+}
+
+// bm25Ranking returns the BM25 ranking for query, taken from the SQLite FTS
+// index while the Bleve index is unavailable
+func (h *Handler) bm25Ranking(query *search.Query, limit int) ([]search.RankedHit, error) {
+	results, err := &search.SearchResults{}, search.ErrIndexUnavailable
+	if h.bm25 != nil {
+		results, err = h.bm25.Search(query, search.SearchOptions{Limit: limit})
+	}
+	if errors.Is(err, search.ErrIndexUnavailable) {
+		// Rank with the SQLite FTS index instead
+		fallback, _, _, err := h.ftsFallbackSearch(query, 0, limit, false, 0)
+		if err != nil {
+			return nil, err
+		}
+		hits := make([]search.RankedHit, 0, len(fallback))
+		for _, result := range fallback {
+			hits = append(hits, search.RankedHit{NoteID: result.Note.ID, Score: result.Score})
+		}
+		return hits, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]search.RankedHit, 0, len(results.Hits))
+	for _, result := range results.Hits {
+		hits = append(hits, search.RankedHit{NoteID: result.Note.ID, Score: result.Score})
+	}
+	return hits, nil
+}
+
+// matchingNotes returns which of noteIDs match the text clauses of
+// constraints, using the SQLite FTS index when the Bleve index is unavailable
+func (h *Handler) matchingNotes(constraints *search.Query, noteIDs []int64) (map[int64]bool, error) {
+	matching, err := map[int64]bool(nil), search.ErrIndexUnavailable
+	if h.bm25 != nil {
+		matching, err = h.bm25.MatchingNotes(constraints, noteIDs)
+	}
+	if !errors.Is(err, search.ErrIndexUnavailable) {
+		return matching, err
+	}
+
+	if match := constraints.FTS5Match(); match != "" {
+		return h.db.MatchingNotesFTS(match)
+	}
+
+	// Only exclusions: every note passes unless it matches one
+	excluded, err := h.db.MatchingNotesFTS(constraints.FTS5Excluded())
+	if err != nil {
+		return nil, err
+	}
+	matching = make(map[int64]bool, len(noteIDs))
+	for _, id := range noteIDs {
+		matching[id] = !excluded[id]
+	}
+	return matching, nil
+}
+
+// semanticRanking returns the semantic ranking for query, best match first.
+// A query with nothing but filters has no text to embed and ranks nothing.
+func (h *Handler) semanticRanking(ctx context.Context, query string, threshold float64, limit int) ([]search.RankedHit, error) {
+	if query == "" {
+		return nil, nil
+	}
+
+	matches, err := h.semantic.Search(ctx, query, threshold, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]search.RankedHit, 0, len(matches))
+	for _, match := range matches {
+		hits = append(hits, search.RankedHit{NoteID: match.ID, Score: match.Score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
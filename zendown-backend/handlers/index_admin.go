@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"zendown/search"
+)
+
+// startBM25Rebuild rebuilds the BM25 index in the background. The returned
+// channel receives the outcome once the new index serves and has been
+// reconciled with changes made during the build.
+func (h *Handler) startBM25Rebuild(reason string) (<-chan error, error) {
+	result := make(chan error, 1)
+
+	log.Printf("Rebuilding BM25 index: %s", reason)
+	err := h.bm25.StartRebuild(reason, h.indexedNotes, func(err error) {
+		if err != nil {
+			log.Printf("BM25 index rebuild failed: %v", err)
+			result <- err
+			return
+		}
+
+		log.Printf("BM25 index rebuild finished, reconciling changes made meanwhile")
+		if _, err := h.Reconcile(false); err != nil {
+			log.Printf("Failed to reconcile indexes after rebuild: %v", err)
+		}
+		result <- nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// indexedNotes loads every live note with its collection names for a rebuild
+func (h *Handler) indexedNotes() ([]search.IndexedNote, error) {
+	notes, err := h.db.GetAllNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := h.db.GetCollectionNamesByNote()
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make([]search.IndexedNote, 0, len(notes))
+	for _, note := range notes {
+		indexed = append(indexed, search.IndexedNote{Note: note, Collections: collections[note.ID]})
+	}
+	return indexed, nil
+}
+
+// RebuildBM25IfNeeded starts a rebuild when the BM25 index is outdated or
+// damaged. It returns nil if no rebuild was needed.
+func (h *Handler) RebuildBM25IfNeeded() <-chan error {
+	if h.bm25 == nil {
+		return nil
+	}
+
+	reason := h.bm25.RebuildReason()
+	if reason == "" {
+		return nil
+	}
+
+	result, err := h.startBM25Rebuild(reason)
+	if err != nil {
+		log.Printf("Failed to start BM25 index rebuild: %v", err)
+		return nil
+	}
+	return result
+}
+
+// GetBM25Rebuild returns the progress of the running or latest BM25 rebuild
+func (h *Handler) GetBM25Rebuild(w http.ResponseWriter, r *http.Request) {
+	if h.bm25 == nil {
+		http.Error(w, "Full-text search service not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.bm25.RebuildStatus())
+}
+
+// RebuildBM25 starts a blue/green rebuild of the BM25 index. The current
+// index keeps serving until the new one is swapped in.
+func (h *Handler) RebuildBM25(w http.ResponseWriter, r *http.Request) {
+	if h.bm25 == nil {
+		http.Error(w, "Full-text search service not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	if _, err := h.startBM25Rebuild("requested by an administrator"); err != nil {
+		if errors.Is(err, search.ErrRebuildInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(h.bm25.RebuildStatus())
+}
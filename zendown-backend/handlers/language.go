@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type SetNoteLanguageRequest struct {
+	// Language is a configured language code, or empty to detect it
+	Language string `json:"language"`
+}
+
+// SetNoteLanguage sets the language a note is analyzed in for full-text
+// search, overriding detection
+func (h *Handler) SetNoteLanguage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetNoteLanguageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	language := strings.ToLower(strings.TrimSpace(req.Language))
+	if language != "" && !h.languages.Supports(language) {
+		http.Error(w, fmt.Sprintf("Unsupported language %q, configured are %s", language, strings.Join(h.languages.Languages, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.db.SetNoteLanguage(id, language)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.indexSync.Notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetBacklinks returns the [[links]] in other notes that point at a note
+func (h *Handler) GetBacklinks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetNote(id); err != nil {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	backlinks, err := h.db.GetBacklinks(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(backlinks)
+}
+
+// GetOutlinks returns the [[links]] written in a note, including unresolved ones
+func (h *Handler) GetOutlinks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetNote(id); err != nil {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	outlinks, err := h.db.GetOutlinks(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outlinks)
+}
+
+// GetUnresolvedLinks returns every link in the vault whose target note does not exist
+func (h *Handler) GetUnresolvedLinks(w http.ResponseWriter, r *http.Request) {
+	unresolved, err := h.db.GetUnresolvedLinks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unresolved)
+}
+
+// rewriteLinksTo points the [[oldTitle]] links that resolved to a renamed
+// note at its new title
+func (h *Handler) rewriteLinksTo(noteID int64, oldTitle, newTitle string) {
+	if err := h.db.RewriteLinksTo(noteID, oldTitle, newTitle); err != nil {
+		log.Printf("Failed to rewrite links to %q: %v", oldTitle, err)
+	}
+}
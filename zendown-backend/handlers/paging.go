@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultPageLimit is the page size of search endpoints unless limit is given
+const defaultPageLimit = 20
+
+// cursorPrefix marks a cursor so offsets and other strings are not mistaken
+// for one
+const cursorPrefix = "offset:"
+
+// Page describes where a page of search results sits among all matches.
+// NextCursor is null on the last page.
+type Page struct {
+	Total      int     `json:"total"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// parsePage reads the limit and offset or cursor query parameters. A cursor
+// is the next_cursor of the previous page and cannot be combined with an
+// offset. An invalid limit falls back to the default, as it always has.
+func parsePage(r *http.Request) (offset, limit int, err error) {
+	params := r.URL.Query()
+
+	limit = defaultPageLimit
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	cursor, offsetParam := params.Get("cursor"), params.Get("offset")
+	switch {
+	case cursor != "" && offsetParam != "":
+		return 0, 0, errors.New("Use either offset or cursor, not both")
+	case cursor != "":
+		offset, err = decodeCursor(cursor)
+		if err != nil {
+			return 0, 0, err
+		}
+	case offsetParam != "":
+		offset, err = strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("Offset must be a non-negative integer")
+		}
+	}
+
+	return offset, limit, nil
+}
+
+// newPage describes the page at offset, with a cursor if matches follow it
+func newPage(offset, limit, total int) Page {
+	page := Page{Total: total, Offset: offset, Limit: limit}
+	if next := offset + limit; next < total {
+		cursor := encodeCursor(next)
+		page.NextCursor = &cursor
+	}
+	return page
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("Invalid cursor")
+	}
+	value, ok := strings.CutPrefix(string(raw), cursorPrefix)
+	if !ok {
+		return 0, errors.New("Invalid cursor")
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, errors.New("Invalid cursor")
+	}
+	return offset, nil
+}
+
+// pageOf returns the part of items on the page at offset
+func pageOf[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"zendown/embedding"
+	"zendown/notetext"
+)
+
+// maxExcerptLength caps the characters of a passage excerpt in responses.
+// The offsets still span the whole chunk.
+const maxExcerptLength = 300
+
+// notePassages prepares the matching passages of a note for a response.
+// Offsets are character offsets into the plain text of content, which was
+// embedded and may have changed since; missing or outdated ones are
+// recovered by finding the chunk's text, and left at 0 if it is gone.
+// Passages are ordered best first.
+func notePassages(content string, passages []embedding.Passage) []embedding.Passage {
+	if len(passages) == 0 {
+		return nil
+	}
+
+	content = notetext.Plain(content)
+	length := utf8.RuneCountInString(content)
+	result := make([]embedding.Passage, 0, len(passages))
+	for _, passage := range passages {
+		if !passageAt(content, length, passage) {
+			passage.Start, passage.End = 0, 0
+			if i := strings.Index(content, passage.Excerpt); i >= 0 && passage.Excerpt != "" {
+				passage.Start = utf8.RuneCountInString(content[:i])
+				passage.End = passage.Start + utf8.RuneCountInString(passage.Excerpt)
+			}
+		}
+		passage.Excerpt = truncateExcerpt(passage.Excerpt)
+		result = append(result, passage)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Score > result[j].Score })
+	return result
+}
+
+// passageAt reports whether the offsets of passage still point at its text
+func passageAt(content string, length int, passage embedding.Passage) bool {
+	if passage.End <= passage.Start || passage.Start < 0 || passage.End > length {
+		return false
+	}
+	if passage.Excerpt == "" {
+		return true
+	}
+	runes := []rune(content)
+	return string(runes[passage.Start:passage.End]) == passage.Excerpt
+}
+
+// truncateExcerpt shortens text to maxExcerptLength characters
+func truncateExcerpt(text string) string {
+	if utf8.RuneCountInString(text) <= maxExcerptLength {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:maxExcerptLength])) + "…"
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"zendown/search"
+)
+
+// parseSearchQuery parses a full-text query and resolves its collection
+// filters. On failure it writes the error response and returns false; syntax
+// errors are reported as 400 with the position of the problem.
+func (h *Handler) parseSearchQuery(w http.ResponseWriter, text string) (*search.Query, bool) {
+	q, err := search.ParseQuery(text)
+	if err != nil {
+		var parseErr *search.ParseError
+		if errors.As(err, &parseErr) {
+			http.Error(w, "Invalid search query: "+parseErr.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if err := q.ResolveCollections(h.db.GetCollectionNoteIDsByName); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return q, true
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"zendown/database"
+)
+
+// ReconcileTargetReport describes how one index differs from the notes table
+type ReconcileTargetReport struct {
+	Target string `json:"target"`
+	// Source is where the index contents were read from: the index itself, or
+	// the sync state recorded by the outbox for indexes that cannot be listed
+	Source   string  `json:"source"`
+	Indexed  int     `json:"indexed"`
+	InSync   int     `json:"in_sync"`
+	Missing  []int64 `json:"missing"`
+	Stale    []int64 `json:"stale"`
+	Orphaned []int64 `json:"orphaned"`
+	// Pending counts notes skipped because jobs for them are already queued
+	Pending int    `json:"pending"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReconcileReport is the outcome of one reconciliation run
+type ReconcileReport struct {
+	StartedAt  time.Time               `json:"started_at"`
+	FinishedAt time.Time               `json:"finished_at"`
+	DryRun     bool                    `json:"dry_run"`
+	Notes      int                     `json:"notes"`
+	Queued     int                     `json:"queued"`
+	Targets    []ReconcileTargetReport `json:"targets"`
+}
+
+// reconcileState serializes reconciliation runs and keeps the latest report
+type reconcileState struct {
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *ReconcileReport
+}
+
+// Reconcile compares the notes table with every index and queues upserts for
+// missing or stale documents and deletes for documents of notes that no
+// longer exist. With dryRun set it only reports the drift.
+func (h *Handler) Reconcile(dryRun bool) (*ReconcileReport, error) {
+	h.reconcile.running.Lock()
+	defer h.reconcile.running.Unlock()
+
+	report := &ReconcileReport{
+		StartedAt: time.Now(),
+		DryRun:    dryRun,
+	}
+
+	notes, err := h.db.GetAllNotes()
+	if err != nil {
+		return nil, err
+	}
+	report.Notes = len(notes)
+
+	live := make(map[int64]time.Time, len(notes))
+	for _, note := range notes {
+		live[note.ID] = note.UpdatedAt
+	}
+
+	for _, target := range h.indexTargets {
+		targetReport := h.reconcileTarget(target, live, dryRun)
+		report.Queued += len(targetReport.Missing) + len(targetReport.Stale) + len(targetReport.Orphaned)
+		report.Targets = append(report.Targets, targetReport)
+	}
+	if dryRun {
+		report.Queued = 0
+	}
+
+	if report.Queued > 0 {
+		h.indexSync.Notify()
+	}
+
+	report.FinishedAt = time.Now()
+
+	h.reconcile.mu.Lock()
+	h.reconcile.last = report
+	h.reconcile.mu.Unlock()
+
+	return report, nil
+}
+
+func (h *Handler) reconcileTarget(target string, live map[int64]time.Time, dryRun bool) ReconcileTargetReport {
+	report := ReconcileTargetReport{
+		Target:   target,
+		Missing:  []int64{},
+		Stale:    []int64{},
+		Orphaned: []int64{},
+	}
+
+	var indexed map[int64]time.Time
+	var err error
+	switch target {
+	case database.IndexTargetBM25:
+		report.Source = "index"
+		if h.bm25 == nil {
+			report.Error = "BM25 search service not available"
+			return report
+		}
+		indexed, err = h.bm25.IndexedNotes()
+	default:
+		// SemWare cannot list its documents, so rely on what the outbox
+		// recorded as successfully indexed
+		report.Source = "sync_state"
+		indexed, err = h.db.GetIndexState(target)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Indexed = len(indexed)
+
+	pending, err := h.db.GetPendingIndexNoteIDs(target)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	for id, updatedAt := range live {
+		if pending[id] {
+			report.Pending++
+			continue
+		}
+
+		indexedAt, ok := indexed[id]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, id)
+		case !sameSecond(indexedAt, updatedAt):
+			report.Stale = append(report.Stale, id)
+		default:
+			report.InSync++
+		}
+	}
+
+	for id := range indexed {
+		if _, ok := live[id]; !ok && !pending[id] {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+
+	// The sync state of this index may have lost track of deleted notes that
+	// other indexes or this index's failed jobs still name
+	if report.Source == "sync_state" {
+		deleted, err := h.db.GetRecordedDeletedNoteIDs(target)
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		for id := range deleted {
+			if _, ok := indexed[id]; !ok && !pending[id] {
+				report.Orphaned = append(report.Orphaned, id)
+			}
+		}
+	}
+
+	sortIDs(report.Missing)
+	sortIDs(report.Stale)
+	sortIDs(report.Orphaned)
+
+	if dryRun {
+		return report
+	}
+
+	enqueue := func(ids []int64, op string) {
+		for _, id := range ids {
+			if err := h.db.EnqueueIndexJob(id, target, op); err != nil {
+				log.Printf("Failed to queue %s of note %d in %s: %v", op, id, target, err)
+			}
+		}
+	}
+	enqueue(report.Missing, database.IndexOpUpsert)
+	enqueue(report.Stale, database.IndexOpUpsert)
+	enqueue(report.Orphaned, database.IndexOpDelete)
+
+	return report
+}
+
+// sameSecond compares timestamps at the resolution SQLite stores them with
+func sameSecond(a, b time.Time) bool {
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
+
+func sortIDs(ids []int64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// DrainIndexSync applies queued index jobs synchronously. It is used by the
+// reconcile command, which runs without the background sync workers.
+func (h *Handler) DrainIndexSync() int {
+	return h.indexSync.Drain()
+}
+
+// StartReconciler reconciles the indexes once in the background and then
+// every interval. A zero interval only runs the initial pass.
+func (h *Handler) StartReconciler(interval time.Duration) {
+	run := func() {
+		report, err := h.Reconcile(false)
+		if err != nil {
+			log.Printf("Failed to reconcile indexes: %v", err)
+			return
+		}
+
+		for _, target := range report.Targets {
+			if target.Error != "" {
+				log.Printf("Failed to reconcile %s: %s", target.Target, target.Error)
+				continue
+			}
+			if drift := len(target.Missing) + len(target.Stale) + len(target.Orphaned); drift > 0 {
+				log.Printf("Reconciled %s: %d missing, %d stale, %d orphaned", target.Target, len(target.Missing), len(target.Stale), len(target.Orphaned))
+			}
+		}
+	}
+
+	go func() {
+		run()
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// RunReconcile reconciles the indexes now and returns the report. Pass
+// dry_run=true to only report the drift.
+func (h *Handler) RunReconcile(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	report, err := h.Reconcile(dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetReconcileReport returns the report of the latest reconciliation run
+func (h *Handler) GetReconcileReport(w http.ResponseWriter, r *http.Request) {
+	h.reconcile.mu.Lock()
+	report := h.reconcile.last
+	h.reconcile.mu.Unlock()
+
+	if report == nil {
+		http.Error(w, "No reconciliation has run yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"zendown/database"
+	"zendown/notetext"
+	"zendown/semware"
+)
+
+// Re-embedding states reported in the progress stream
+const (
+	reembedRunning = "running"
+	reembedDone    = "done"
+	reembedFailed  = "failed"
+)
+
+// ReembedProgress is one line of the re-embedding progress stream. The last
+// line is done or failed and lists the notes that could not be embedded.
+type ReembedProgress struct {
+	State string `json:"state"`
+	semware.BatchProgress
+	Errors []semware.BatchError `json:"errors,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// Reembed sends every note to SemWare again, streaming progress as
+// newline-delimited JSON. SemWare has no batch endpoint, so notes are sent
+// one per request, concurrency at a time, with progress reported every
+// progress_every notes per worker. The run is tied to the request, so it
+// stops when the client disconnects; notes already sent keep their new
+// embeddings.
+func (h *Handler) Reembed(w http.ResponseWriter, r *http.Request) {
+	if h.semware == nil {
+		http.Error(w, "Re-embedding needs SemWare as the semantic backend", http.StatusConflict)
+		return
+	}
+
+	opts := semware.DefaultBatchOptions()
+	params := r.URL.Query()
+	if progressEvery := params.Get("progress_every"); progressEvery != "" {
+		n, err := strconv.Atoi(progressEvery)
+		if err != nil || n <= 0 {
+			http.Error(w, "progress_every must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		opts.ProgressEvery = n
+	}
+	if concurrency := params.Get("concurrency"); concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n <= 0 {
+			http.Error(w, "concurrency must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		opts.Concurrency = n
+	}
+
+	if !h.reembedding.TryLock() {
+		http.Error(w, "A re-embedding is already running", http.StatusConflict)
+		return
+	}
+	defer h.reembedding.Unlock()
+
+	notes, err := h.db.GetAllNotes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	docs := make([]semware.UpsertRequest, 0, len(notes))
+	for _, note := range notes {
+		docs = append(docs, semware.UpsertRequest{ID: strconv.FormatInt(note.ID, 10), Content: notetext.Plain(note.Content)})
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	send := func(progress ReembedProgress) {
+		encoder.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	last := semware.BatchProgress{Total: len(docs), Remaining: len(docs)}
+	send(ReembedProgress{State: reembedRunning, BatchProgress: last})
+
+	opts.Progress = func(progress semware.BatchProgress) {
+		last = progress
+		send(ReembedProgress{State: reembedRunning, BatchProgress: progress})
+	}
+	failures, err := h.semware.UpsertDocuments(r.Context(), docs, opts)
+
+	final := ReembedProgress{State: reembedDone, BatchProgress: last, Errors: failures}
+	if err != nil {
+		log.Printf("Re-embedding stopped after %d of %d notes: %v", last.Total-last.Remaining, last.Total, err)
+		final.State = reembedFailed
+		final.Error = err.Error()
+		send(final)
+		return
+	}
+
+	h.markReembedded(notes, failures)
+	log.Printf("Re-embedded %d notes, %d failed", last.Upserted, last.Failed)
+	send(final)
+}
+
+// markReembedded records the notes of a finished re-embedding as indexed in
+// SemWare, so reconciliation does not send them again. Notes changed or
+// deleted since they were read may have had their newer version replaced
+// by the one sent, so they are queued for SemWare again instead.
+func (h *Handler) markReembedded(notes []*database.Note, failures []semware.BatchError) {
+	failed := make(map[string]bool, len(failures))
+	for _, failure := range failures {
+		failed[failure.ID] = true
+	}
+
+	for _, note := range notes {
+		if failed[strconv.FormatInt(note.ID, 10)] {
+			continue
+		}
+
+		current, err := h.db.GetNote(note.ID)
+		if err != nil || !current.UpdatedAt.Equal(note.UpdatedAt) {
+			op := database.IndexOpUpsert
+			if errors.Is(err, sql.ErrNoRows) {
+				op = database.IndexOpDelete
+			}
+			if err := h.db.EnqueueIndexJob(note.ID, database.IndexTargetSemWare, op); err != nil {
+				log.Printf("Failed to queue %s of note %d in SemWare: %v", op, note.ID, err)
+			}
+			continue
+		}
+
+		if err := h.db.MarkIndexed(note.ID, database.IndexTargetSemWare, note.UpdatedAt); err != nil {
+			log.Printf("Failed to record SemWare index state of note %d: %v", note.ID, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"zendown/database"
+	"zendown/embedding"
+	"zendown/search"
+)
+
+// Related notes modes
+const (
+	relatedModeAuto     = "auto"
+	relatedModeSemantic = "semantic"
+	relatedModeLexical  = "lexical"
+)
+
+// lexicalRelated finds notes sharing the distinctive terms of note in the
+// BM25 index. It needs no semantic backend, so related notes keep working
+// while SemWare is unreachable or not deployed. Like semantic lookups it
+// returns at most topK notes, or the default top_k when topK is 0.
+func (h *Handler) lexicalRelated(note *database.Note, threshold float64, topK int) ([]embedding.Match, error) {
+	if h.bm25 == nil {
+		return nil, search.ErrIndexUnavailable
+	}
+
+	if topK <= 0 {
+		topK = database.DefaultSemanticSettings().TopK
+	}
+
+	hits, err := h.bm25.MoreLikeThis(note, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []embedding.Match{}
+	for _, hit := range hits {
+		if hit.Score >= threshold {
+			matches = append(matches, embedding.Match{ID: hit.NoteID, Score: hit.Score})
+		}
+	}
+	return matches, nil
+}
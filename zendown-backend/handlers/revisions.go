@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"zendown/diff"
+
+	"github.com/gorilla/mux"
+)
+
+// RevisionDiffSide identifies one end of a revision diff
+type RevisionDiffSide struct {
+	RevisionID int64  `json:"revision_id,omitempty"`
+	Title      string `json:"title"`
+}
+
+// RevisionDiffResponse is a block-level diff between two states of a note
+type RevisionDiffResponse struct {
+	From  RevisionDiffSide `json:"from"`
+	To    RevisionDiffSide `json:"to"`
+	Stats diff.Stats       `json:"stats"`
+	Lines []diff.Line      `json:"lines"`
+}
+
+// GetNoteRevisions lists the saved revisions of a note, newest first
+func (h *Handler) GetNoteRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetNote(id); err != nil {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := h.db.GetNoteRevisions(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetNoteRevision returns a single revision including its content
+func (h *Handler) GetNoteRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	revID, err := strconv.ParseInt(vars["rev"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.db.GetNoteRevision(id, revID)
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// RestoreNoteRevision replaces the note's title and content with a revision
+func (h *Handler) RestoreNoteRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	revID, err := strconv.ParseInt(vars["rev"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetNoteRevision(id, revID); err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	note, err := h.db.RestoreNoteRevision(id, revID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to restore revision %d of note %d: %v", revID, id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.noteChanged(note.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
+
+// DiffNoteRevisions compares two revisions of a note. The "from" revision is
+// required; when "to" is omitted the diff is taken against the current note.
+func (h *Handler) DiffNoteRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	fromID, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "A valid 'from' revision ID is required", http.StatusBadRequest)
+		return
+	}
+
+	from, err := h.db.GetNoteRevision(id, fromID)
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	var toSide RevisionDiffSide
+	var toContent string
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		toID, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'to' revision ID", http.StatusBadRequest)
+			return
+		}
+
+		to, err := h.db.GetNoteRevision(id, toID)
+		if err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		toSide = RevisionDiffSide{RevisionID: to.ID, Title: to.Title}
+		toContent = to.Content
+	} else {
+		note, err := h.db.GetNote(id)
+		if err != nil {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		toSide = RevisionDiffSide{Title: note.Title}
+		toContent = note.Content
+	}
+
+	lines := diff.Lines(diff.SplitHTML(from.Content), diff.SplitHTML(toContent))
+
+	response := RevisionDiffResponse{
+		From:  RevisionDiffSide{RevisionID: from.ID, Title: from.Title},
+		To:    toSide,
+		Stats: diff.Summarize(lines),
+		Lines: lines,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"zendown/database"
+	"zendown/embedding"
+	"zendown/search"
+	"zendown/semware"
+
+	"github.com/gorilla/mux"
+)
+
+// savedSearchLimit is the number of results returned unless the saved search
+// or the request sets one
+const savedSearchLimit = 50
+
+// savedSearchCountLimit caps how many matches are counted for modes that
+// cannot report a total without fetching every hit
+const savedSearchCountLimit = 1000
+
+// SavedSearchRequest is the body of saved search create and update requests
+type SavedSearchRequest struct {
+	Name          string                      `json:"name"`
+	Query         string                      `json:"query"`
+	Mode          string                      `json:"mode"`
+	Filters       database.SavedSearchFilters `json:"filters"`
+	ShowInSidebar bool                        `json:"show_in_sidebar"`
+}
+
+// SavedSearchResult is a note matched by a saved search. Score is on the
+// scale of the search mode; LIKE searches rank by the FTS score.
+type SavedSearchResult struct {
+	Note      *database.Note      `json:"note"`
+	Score     float64             `json:"score"`
+	Fragments map[string][]string `json:"fragments,omitempty"`
+	Passages  []embedding.Passage `json:"passages,omitempty"`
+}
+
+// SavedSearchResultsResponse holds the results of running a saved search.
+// Count is the number of matches, which can exceed the results returned.
+type SavedSearchResultsResponse struct {
+	SavedSearch *database.SavedSearch `json:"saved_search"`
+	Results     []SavedSearchResult   `json:"results"`
+	Count       int                   `json:"count"`
+}
+
+// GetSavedSearches lists saved searches. With sidebar=true only those shown
+// in the sidebar are listed, and counts invalidated since they were last
+// cached are computed again.
+func (h *Handler) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	sidebar := r.URL.Query().Get("sidebar") == "true"
+
+	searches, err := h.db.GetSavedSearches(sidebar)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sidebar {
+		for _, saved := range searches {
+			if saved.CachedCount != nil {
+				continue
+			}
+			_, count, err := h.runSavedSearch(r.Context(), saved, 1)
+			if err != nil {
+				log.Printf("Failed to count saved search %d: %v", saved.ID, err)
+				continue
+			}
+			saved.CachedCount = &count
+			h.cacheSavedSearchCount(saved, count)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searches)
+}
+
+func (h *Handler) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved := req.savedSearch()
+	if err := h.validateSavedSearch(saved); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved, err := h.db.CreateSavedSearch(saved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) GetSavedSearch(w http.ResponseWriter, r *http.Request) {
+	saved, ok := h.savedSearchFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) UpdateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved := req.savedSearch()
+	saved.ID = id
+	if err := h.validateSavedSearch(saved); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved, err = h.db.UpdateSavedSearch(saved)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.DeleteSavedSearch(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSavedSearchResults runs a saved search and caches its result count.
+// The limit query parameter overrides the limit stored in its filters.
+func (h *Handler) GetSavedSearchResults(w http.ResponseWriter, r *http.Request) {
+	saved, ok := h.savedSearchFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit := savedSearchLimit
+	if saved.Filters.Limit > 0 {
+		limit = saved.Filters.Limit
+	}
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	results, count, err := h.runSavedSearch(r.Context(), saved, limit)
+	if errors.Is(err, errHybridUnavailable) {
+		http.Error(w, "Both search backends are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, semware.ErrUnavailable) {
+		http.Error(w, "Semantic features unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to run saved search %d: %v", saved.ID, err)
+		http.Error(w, fmt.Sprintf("Failed to run saved search: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	saved.CachedCount = &count
+	h.cacheSavedSearchCount(saved, count)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SavedSearchResultsResponse{
+		SavedSearch: saved,
+		Results:     results,
+		Count:       count,
+	})
+}
+
+func (req SavedSearchRequest) savedSearch() *database.SavedSearch {
+	return &database.SavedSearch{
+		Name:          strings.TrimSpace(req.Name),
+		Query:         strings.TrimSpace(req.Query),
+		Mode:          strings.ToLower(req.Mode),
+		Filters:       req.Filters,
+		ShowInSidebar: req.ShowInSidebar,
+	}
+}
+
+func (h *Handler) savedSearchFromRequest(w http.ResponseWriter, r *http.Request) (*database.SavedSearch, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	saved, err := h.db.GetSavedSearch(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return saved, true
+}
+
+// validateSavedSearch checks that a saved search can run, so errors surface
+// when it is saved rather than every time it is used
+func (h *Handler) validateSavedSearch(saved *database.SavedSearch) error {
+	if saved.Name == "" {
+		return errors.New("Name is required")
+	}
+	if !slices.Contains(database.SearchModes, saved.Mode) {
+		return fmt.Errorf("Mode must be one of %s", strings.Join(database.SearchModes, ", "))
+	}
+	if saved.Filters.Limit < 0 {
+		return errors.New("Limit must not be negative")
+	}
+	if strings.Contains(saved.Filters.Collection, `"`) {
+		return errors.New("Collection filter must not contain quotes")
+	}
+
+	filters := savedSearchFilterClauses(saved.Filters)
+	switch saved.Mode {
+	case database.SearchModeLike, database.SearchModeSemantic:
+		if saved.Query == "" {
+			return errors.New("Query is required")
+		}
+		if filters != "" {
+			if _, err := search.ParseQuery(filters); err != nil {
+				return fmt.Errorf("Invalid filters: %v", err)
+			}
+		}
+	default:
+		if saved.Query == "" && filters == "" {
+			return errors.New("Query or filters are required")
+		}
+		if _, err := search.ParseQuery(strings.TrimSpace(saved.Query + " " + filters)); err != nil {
+			return fmt.Errorf("Invalid search query: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// savedSearchFilterClauses writes the filters of a saved search in the query
+// syntax, so every mode filters exactly like full-text search
+func savedSearchFilterClauses(filters database.SavedSearchFilters) string {
+	var clauses []string
+	if filters.Collection != "" {
+		clauses = append(clauses, `collection:"`+filters.Collection+`"`)
+	}
+	if filters.Created != "" {
+		clauses = append(clauses, "created:"+filters.Created)
+	}
+	if filters.Updated != "" {
+		clauses = append(clauses, "updated:"+filters.Updated)
+	}
+	return strings.Join(clauses, " ")
+}
+
+// savedSearchQuery parses the part of a saved search that the search package
+// understands: the whole query for BM25 and hybrid searches, only the
+// filters for the others. It returns nil if there is nothing to parse.
+func (h *Handler) savedSearchQuery(saved *database.SavedSearch) (*search.Query, error) {
+	text := savedSearchFilterClauses(saved.Filters)
+	if saved.Mode == database.SearchModeBM25 || saved.Mode == database.SearchModeHybrid {
+		text = strings.TrimSpace(saved.Query + " " + text)
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	parsed, err := search.ParseQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.ResolveCollections(h.db.GetCollectionNoteIDsByName); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// runSavedSearch returns up to limit results of a saved search and the
+// number of matches
+func (h *Handler) runSavedSearch(ctx context.Context, saved *database.SavedSearch, limit int) ([]SavedSearchResult, int, error) {
+	parsed, err := h.savedSearchQuery(saved)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	threshold := h.semanticSettings().DefaultThreshold
+	if saved.Filters.Threshold != nil {
+		threshold = *saved.Filters.Threshold
+	}
+
+	var results []SavedSearchResult
+	count := 0
+
+	switch saved.Mode {
+	case database.SearchModeLike:
+		matches, _, err := h.db.SearchNotes(saved.Query, 0, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, match := range matches {
+			if parsed != nil && !parsed.Filter(match.Note) {
+				continue
+			}
+			results = append(results, SavedSearchResult{
+				Note:      match.Note,
+				Score:     match.Score,
+				Fragments: map[string][]string{"content": {match.Snippet}},
+			})
+		}
+		count = len(results)
+
+	case database.SearchModeBM25:
+		err := search.ErrIndexUnavailable
+		var searchResults *search.SearchResults
+		if h.bm25 != nil {
+			searchResults, err = h.bm25.Search(parsed, search.SearchOptions{Limit: limit})
+		}
+		if errors.Is(err, search.ErrIndexUnavailable) {
+			fallback, _, _, err := h.ftsFallbackSearch(parsed, 0, savedSearchCountLimit, false, 0)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, result := range fallback {
+				results = append(results, SavedSearchResult{Note: result.Note, Score: result.Score, Fragments: result.Fragments})
+			}
+			count = len(results)
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, hit := range searchResults.Hits {
+			results = append(results, SavedSearchResult{Note: hit.Note, Score: hit.Score, Fragments: hit.Fragments})
+		}
+		count = int(searchResults.Total)
+
+	case database.SearchModeSemantic:
+		matches, err := h.semantic.Search(ctx, saved.Query, threshold, savedSearchCountLimit)
+		if err != nil {
+			return nil, 0, err
+		}
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+		for _, match := range matches {
+			// The semantic index may briefly lag behind deletions
+			note, err := h.db.GetNote(match.ID)
+			if err != nil {
+				continue
+			}
+			if parsed != nil && !parsed.Filter(note) {
+				continue
+			}
+			results = append(results, SavedSearchResult{Note: note, Score: match.Score, Passages: notePassages(note.Content, match.Passages)})
+		}
+		count = len(results)
+
+	case database.SearchModeHybrid:
+		opts := defaultHybridOptions(savedSearchCountLimit, threshold)
+		response, err := h.hybridSearch(ctx, parsed, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, hit := range response.Results {
+			results = append(results, SavedSearchResult{Note: hit.Note, Score: hit.Score})
+		}
+		count = len(results)
+
+	default:
+		return nil, 0, fmt.Errorf("unknown search mode %q", saved.Mode)
+	}
+
+	if results == nil {
+		results = []SavedSearchResult{}
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, count, nil
+}
+
+// cacheSavedSearchCount stores a fresh count. A count taken while the
+// indexes the mode reads from have pending jobs may miss the latest changes,
+// so it is not cached. LIKE searches read the notes table directly. Changes
+// made while the search ran are caught by the count version of saved, which
+// was read before it.
+func (h *Handler) cacheSavedSearchCount(saved *database.SavedSearch, count int) {
+	var pending int
+	var err error
+	switch saved.Mode {
+	case database.SearchModeBM25:
+		pending, err = h.db.CountPendingIndexJobs(database.IndexTargetBM25)
+	case database.SearchModeSemantic, database.SearchModeHybrid:
+		pending, err = h.db.CountPendingIndexJobs()
+	}
+	if err != nil || pending > 0 {
+		return
+	}
+
+	if err := h.db.SetSavedSearchCount(saved.ID, count, saved.CountVersion); err != nil {
+		log.Printf("Failed to cache count of saved search %d: %v", saved.ID, err)
+	}
+}
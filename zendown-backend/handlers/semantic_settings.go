@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"zendown/database"
+	"zendown/embedding"
+)
+
+// maxSemanticTopK bounds the top_k setting
+const maxSemanticTopK = 1000
+
+// semanticSettings returns the stored semantic settings, or the defaults if
+// they cannot be read
+func (h *Handler) semanticSettings() database.SemanticSettings {
+	settings, err := h.db.GetSemanticSettings()
+	if err != nil {
+		log.Printf("Failed to read semantic settings, using defaults: %v", err)
+		return database.DefaultSemanticSettings()
+	}
+	return *settings
+}
+
+// applySemanticSettings configures the SemWare store for settings. Cached
+// similarities were scored under the old settings and are dropped.
+func (h *Handler) applySemanticSettings(settings database.SemanticSettings) {
+	if h.semwareStore != nil {
+		h.semwareStore.Configure(embedding.SemWareConfig{
+			DistanceMetric: settings.DistanceMetric,
+			ScoreMin:       settings.ScoreMin,
+			ScoreMax:       settings.ScoreMax,
+		})
+	}
+	h.similarity.clear()
+}
+
+// semanticThreshold reads the threshold query parameter, falling back to the
+// default threshold of settings
+func semanticThreshold(r *http.Request, settings database.SemanticSettings) float64 {
+	if t, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64); err == nil {
+		return t
+	}
+	return settings.DefaultThreshold
+}
+
+func validateSemanticSettings(settings database.SemanticSettings) error {
+	if !slices.Contains(database.DistanceMetrics, settings.DistanceMetric) {
+		return fmt.Errorf("Distance metric must be one of %v", database.DistanceMetrics)
+	}
+	if settings.DefaultThreshold < 0 || settings.DefaultThreshold > 1 {
+		return errors.New("Default threshold must be between 0 and 1")
+	}
+	if settings.TopK < 1 || settings.TopK > maxSemanticTopK {
+		return fmt.Errorf("Top k must be between 1 and %d", maxSemanticTopK)
+	}
+	if settings.ScoreMin >= settings.ScoreMax {
+		return errors.New("Score min must be less than score max")
+	}
+	return nil
+}
+
+// GetSemanticSettings returns the settings used by every semantic query
+func (h *Handler) GetSemanticSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.db.GetSemanticSettings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateSemanticSettings changes the semantic settings. Fields left out of
+// the body keep their current values.
+func (h *Handler) UpdateSemanticSettings(w http.ResponseWriter, r *http.Request) {
+	current, err := h.db.GetSemanticSettings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	settings := *current
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSemanticSettings(settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.db.UpdateSemanticSettings(settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.applySemanticSettings(*updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"zendown/semware"
+)
+
+// semwareStatus keeps the latest SemWare probe
+type semwareStatus struct {
+	mu   sync.Mutex
+	last *semware.ProbeResult
+}
+
+// SemWareStatus describes SemWare as the latest probe saw it. Probe is null
+// until the first probe finishes.
+type SemWareStatus struct {
+	URL     string               `json:"url"`
+	Breaker string               `json:"breaker"`
+	Probe   *semware.ProbeResult `json:"probe"`
+}
+
+// StatusResponse reports which semantic backend is in use and, if SemWare is
+// one of them, how it is doing
+type StatusResponse struct {
+	SemanticBackend string         `json:"semantic_backend"`
+	SemWare         *SemWareStatus `json:"semware"`
+}
+
+// StartSemWareProbe probes SemWare once in the background and then every
+// interval. A zero interval only runs the startup probe. Changes of the
+// outcome are logged, so a misconfigured key or an unreachable SemWare shows
+// up at startup.
+func (h *Handler) StartSemWareProbe(interval time.Duration) {
+	if h.semware == nil {
+		return
+	}
+
+	probe := func() {
+		result := h.semware.Probe(context.Background())
+
+		h.semwareStatus.mu.Lock()
+		previous := h.semwareStatus.last
+		h.semwareStatus.last = &result
+		h.semwareStatus.mu.Unlock()
+
+		if previous != nil && previous.Status == result.Status {
+			return
+		}
+		switch result.Status {
+		case semware.StatusOK:
+			version := result.Version
+			if version == "" {
+				version = "unknown version"
+			}
+			log.Printf("SemWare at %s is available (%s, %.0fms)", h.semware.BaseURL(), version, result.LatencyMS)
+		case semware.StatusUnconfigured:
+			// NewClient already warned about the key
+		default:
+			log.Printf("Warning: SemWare at %s is %s: %s", h.semware.BaseURL(), result.Status, result.Error)
+		}
+	}
+
+	go func() {
+		probe()
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			probe()
+		}
+	}()
+}
+
+// GetStatus reports the semantic backend and the latest SemWare probe
+func (h *Handler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	response := StatusResponse{SemanticBackend: h.semanticBackend}
+	if h.semware != nil {
+		h.semwareStatus.mu.Lock()
+		probe := h.semwareStatus.last
+		h.semwareStatus.mu.Unlock()
+
+		response.SemWare = &SemWareStatus{
+			URL:     h.semware.BaseURL(),
+			Breaker: h.semware.BreakerState(),
+			Probe:   probe,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
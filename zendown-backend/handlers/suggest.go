@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"zendown/search"
+)
+
+// SuggestNotes completes text typed into the quick switcher with matching
+// note titles and corrections for misspelled words
+func (h *Handler) SuggestNotes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 8
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, 50)
+	}
+
+	if h.bm25 == nil {
+		http.Error(w, "Full-text search service not available", http.StatusInternalServerError)
+		return
+	}
+
+	suggestions, err := h.bm25.Suggest(query, limit)
+	if err != nil {
+		log.Printf("SuggestNotes: %v", err)
+		if errors.Is(err, search.ErrIndexUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
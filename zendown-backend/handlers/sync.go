@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"zendown/database"
+	"zendown/embedding"
+	"zendown/search"
+)
+
+// vectorTarget applies outbox jobs to a semantic vector store
+type vectorTarget struct {
+	store embedding.VectorStore
+}
+
+func (t vectorTarget) Upsert(note *database.Note) error {
+	return t.store.Upsert(context.Background(), note.ID, note.Content)
+}
+
+func (t vectorTarget) Delete(noteID int64) error {
+	return t.store.Delete(context.Background(), noteID)
+}
+
+// bm25Target applies outbox jobs to the Bleve index
+type bm25Target struct {
+	service *search.BM25SearchService
+	db      *database.DB
+}
+
+func (t bm25Target) Upsert(note *database.Note) error {
+	collections, err := t.db.GetNoteCollections(note.ID)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(collections))
+	for _, collection := range collections {
+		names = append(names, collection.Name)
+	}
+	return t.service.IndexNote(note, names)
+}
+
+func (t bm25Target) Delete(noteID int64) error {
+	return t.service.RemoveNote(noteID)
+}
+
+// SyncStatusResponse reports how far the indexes lag behind the database
+type SyncStatusResponse struct {
+	Pending     int                  `json:"pending"`
+	Failed      int                  `json:"failed"`
+	PendingJobs []*database.IndexJob `json:"pending_jobs"`
+	FailedJobs  []*database.IndexJob `json:"failed_jobs"`
+}
+
+// StartIndexSync starts the worker pool that drains the index outbox
+func (h *Handler) StartIndexSync() {
+	h.indexSync.Start()
+}
+
+// GetSyncStatus reports pending and failed index synchronization jobs
+func (h *Handler) GetSyncStatus(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.db.CountIndexJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pending, err := h.db.GetIndexJobs(database.IndexJobPending, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	failed, err := h.db.GetIndexJobs(database.IndexJobFailed, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := SyncStatusResponse{
+		Pending:     counts[database.IndexJobPending],
+		Failed:      counts[database.IndexJobFailed],
+		PendingJobs: pending,
+		FailedJobs:  failed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RetryFailedSync moves failed index jobs back into the queue
+func (h *Handler) RetryFailedSync(w http.ResponseWriter, r *http.Request) {
+	requeued, err := h.db.RequeueFailedIndexJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.indexSync.Notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"requeued": requeued})
+}
+
+// IndexTargets returns the indexes the configured backends use. Pass them to
+// database.DB.SetIndexTargets so note changes only queue jobs for them.
+func (h *Handler) IndexTargets() []string {
+	return h.indexTargets
+}
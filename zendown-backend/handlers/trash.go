@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetTrash lists all notes currently in the trash
+func (h *Handler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	notes, err := h.db.GetTrashedNotes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// RestoreNote takes a note out of the trash and re-indexes it
+func (h *Handler) RestoreNote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.db.RestoreNote(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found in trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.noteChanged(note.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
+
+// DeleteNotePermanently removes a trashed note for good
+func (h *Handler) DeleteNotePermanently(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteNotePermanently(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Note not found in trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.noteChanged(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash permanently deletes every note in the trash
+func (h *Handler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	ids, err := h.db.PurgeTrash(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, id := range ids {
+		h.noteChanged(id)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartTrashPurger periodically deletes notes that have been in the trash for
+// longer than retention
+func (h *Handler) StartTrashPurger(retention, interval time.Duration) {
+	purge := func() {
+		ids, err := h.db.PurgeTrash(retention)
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+			return
+		}
+
+		for _, id := range ids {
+			h.noteChanged(id)
+		}
+
+		if len(ids) > 0 {
+			log.Printf("Purged %d notes from trash", len(ids))
+		}
+	}
+
+	go func() {
+		purge()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purge()
+		}
+	}()
+}
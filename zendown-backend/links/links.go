@@ -0,0 +1,136 @@
+package links
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Ref is a single [[...]] reference found in a note
+type Ref struct {
+	// Target is the text before the pipe: a note title, or a note ID when
+	// the link has an alias
+	Target string
+	// Alias is the optional display text after the pipe
+	Alias string
+}
+
+// NoteID returns the referenced note ID for [[id|alias]] style links. Without
+// an alias a numeric target such as [[2024]] is a title.
+func (r Ref) NoteID() (int64, bool) {
+	if r.Alias == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(r.Target, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// textEscaper escapes text the same way the editor serializes text nodes
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// linkRegex matches [[Target]] and [[Target|Alias]]
+var linkRegex = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// blockElements end a run of text so that a link cannot span two paragraphs
+var blockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "br": true, "pre": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"tr": true, "td": true, "th": true,
+}
+
+// Parse extracts wiki-style references from editor HTML. Links inside code
+// blocks are ignored since they are usually examples rather than real links.
+func Parse(content string) []Ref {
+	var refs []Ref
+	seen := make(map[Ref]bool)
+
+	for _, match := range linkRegex.FindAllStringSubmatch(visibleText(content), -1) {
+		ref := Ref{
+			Target: strings.TrimSpace(match[1]),
+			Alias:  strings.TrimSpace(match[2]),
+		}
+		if ref.Target == "" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+
+	return refs
+}
+
+// Rewrite renames every title reference to oldTitle in content so that it
+// points at newTitle, keeping aliases. Matching is case-insensitive like link
+// resolution. Like Parse it leaves code blocks alone; the rest of the HTML
+// is kept byte for byte. It reports whether anything was changed.
+func Rewrite(content, oldTitle, newTitle string) (string, bool) {
+	pattern := regexp.MustCompile(`(?i)\[\[\s*` + regexp.QuoteMeta(textEscaper.Replace(oldTitle)) + `\s*(\|[^\[\]\n]+)?\]\]`)
+	replacement := "[[" + strings.ReplaceAll(textEscaper.Replace(newTitle), "$", "$$") + "${1}]]"
+
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	skipped := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		raw := string(tokenizer.Raw())
+
+		switch tokenType {
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] {
+				if tokenType == html.StartTagToken {
+					skipped++
+				} else if skipped > 0 {
+					skipped--
+				}
+			}
+		case html.TextToken:
+			if skipped == 0 {
+				raw = pattern.ReplaceAllString(raw, replacement)
+			}
+		}
+		b.WriteString(raw)
+	}
+
+	rewritten := b.String()
+	return rewritten, rewritten != content
+}
+
+// skippedElements hold text that is not searched for links
+var skippedElements = map[string]bool{"code": true, "pre": true, "script": true, "style": true}
+
+func visibleText(content string) string {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && skippedElements[n.Data] {
+			b.WriteString("\n")
+			return
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			b.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	return b.String()
+}
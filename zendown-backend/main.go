@@ -2,16 +2,23 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"zendown/database"
+	"zendown/embedding"
 	"zendown/handlers"
+	"zendown/search"
 
 	"github.com/gorilla/mux"
 )
@@ -20,6 +27,32 @@ import (
 var embeddedFS embed.FS
 
 func main() {
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "print pending database migrations and exit")
+	reconcile := flag.Bool("reconcile", false, "repair drift between the notes and the search indexes and exit")
+	reconcileDryRun := flag.Bool("reconcile-dry-run", false, "report drift between the notes and the search indexes and exit")
+	flag.Parse()
+
+	dbPath := "data/zendown.db"
+
+	// Report pending migrations without touching the database
+	if *migrateDryRun {
+		current, pending, err := database.PendingMigrations(dbPath)
+		if err != nil {
+			log.Fatal("Failed to plan migrations:", err)
+		}
+
+		fmt.Printf("Database schema version: %d\n", current)
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+			return
+		}
+		fmt.Printf("%d pending migrations:\n", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %03d  %s\n", m.Version, m.Description)
+		}
+		return
+	}
+
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll("data", 0755); err != nil {
 		log.Fatal("Failed to create data directory:", err)
@@ -30,23 +63,110 @@ func main() {
 		log.Fatal("Failed to create attachments directory:", err)
 	}
 
-	// Initialize database
-	dbPath := "data/zendown.db"
+	// Configure how auto-save bursts are folded into note revisions
+	if window := os.Getenv("REVISION_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("Invalid REVISION_WINDOW %q: %v", window, err)
+		}
+		database.RevisionWindow = d
+	}
+
+	// Initialize database, applying any pending migrations
 	db, err := database.NewDB(dbPath)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
+	// Choose where semantic search runs: SemWare, the built-in local index,
+	// or SemWare with the local index as a fallback
+	semanticBackend := os.Getenv("SEMANTIC_BACKEND")
+	if semanticBackend == "" {
+		semanticBackend = embedding.BackendAuto
+	}
+	if semanticBackend != embedding.BackendAuto && semanticBackend != embedding.BackendSemWare && semanticBackend != embedding.BackendLocal {
+		log.Fatalf("Invalid SEMANTIC_BACKEND %q: must be auto, semware or local", semanticBackend)
+	}
+
+	// Languages the full-text index analyzes, and the one used for notes in
+	// other languages or whose language cannot be detected
+	languages, err := search.ParseLanguageConfig(os.Getenv("SEARCH_LANGUAGES"), os.Getenv("SEARCH_DEFAULT_LANGUAGE"))
+	if err != nil {
+		log.Fatalf("Invalid search language configuration: %v", err)
+	}
+
 	// Initialize handlers
-	h := handlers.NewHandler(db)
+	h := handlers.NewHandler(db, semanticBackend, languages)
 
-	// Rebuild BM25 index on startup (in background)
-	go func() {
-		if err := h.RebuildBM25Index(); err != nil {
-			log.Printf("Failed to rebuild BM25 index: %v", err)
+	// Only queue index jobs for the indexes the handlers keep in sync
+	db.SetIndexTargets(h.IndexTargets())
+
+	// Compare the indexes with the notes once, apply the repairs and exit.
+	// The server must not be running since it holds the BM25 index open.
+	if *reconcile || *reconcileDryRun {
+		if rebuilt := h.RebuildBM25IfNeeded(); rebuilt != nil {
+			if err := <-rebuilt; err != nil {
+				log.Fatal("Failed to rebuild BM25 index:", err)
+			}
+		}
+
+		report, err := h.Reconcile(*reconcileDryRun)
+		if err != nil {
+			log.Fatal("Failed to reconcile indexes:", err)
+		}
+		// Also applies repairs queued by the reconciliation after a rebuild
+		if !*reconcileDryRun {
+			if applied := h.DrainIndexSync(); applied > 0 {
+				log.Printf("Applied %d index jobs", applied)
+			}
 		}
-	}()
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	// Apply queued index changes to SemWare and the BM25 index
+	h.StartIndexSync()
+
+	// Replace an outdated or damaged BM25 index while the old one serves
+	h.RebuildBM25IfNeeded()
+
+	// Detect and repair index drift on startup and then periodically
+	reconcileInterval := 6 * time.Hour
+	if interval := os.Getenv("RECONCILE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_INTERVAL %q: %v", interval, err)
+		}
+		reconcileInterval = d
+	}
+	h.StartReconciler(reconcileInterval)
+
+	// Check SemWare's availability, version and API key on startup and then
+	// periodically
+	probeInterval := time.Minute
+	if interval := os.Getenv("SEMWARE_PROBE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid SEMWARE_PROBE_INTERVAL %q: %v", interval, err)
+		}
+		probeInterval = d
+	}
+	h.StartSemWareProbe(probeInterval)
+
+	// Permanently delete notes that have been in the trash too long
+	retentionDays := 30
+	if days := os.Getenv("TRASH_RETENTION_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid TRASH_RETENTION_DAYS %q", days)
+		}
+		retentionDays = n
+	}
+	h.StartTrashPurger(time.Duration(retentionDays)*24*time.Hour, time.Hour)
 
 	// Create router
 	router := mux.NewRouter()
@@ -0,0 +1,104 @@
+package notetext
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LanguageCJK covers Chinese, Japanese and Korean, which share one analyzer
+const LanguageCJK = "cjk"
+
+// stopWords are frequent words that mostly occur in one language. Counting
+// them is enough to tell apart languages written in the Latin script.
+var stopWords = map[string][]string{
+	"da": {"og", "jeg", "det", "ikke", "til", "af", "som", "på", "med", "har", "er", "mig", "havde", "hvad", "hvor", "efter", "også", "kun", "være", "når"},
+	"de": {"und", "der", "die", "das", "nicht", "ist", "ich", "zu", "den", "mit", "sich", "auf", "für", "dem", "auch", "eine", "ein", "wird", "sind", "oder", "aber", "wenn", "nach", "bei"},
+	"en": {"the", "and", "is", "of", "to", "in", "that", "it", "with", "for", "was", "this", "are", "be", "have", "not", "you", "from", "which", "but", "or", "what", "can", "will"},
+	"es": {"el", "la", "que", "los", "las", "del", "por", "una", "con", "para", "es", "se", "no", "lo", "como", "pero", "más", "su", "al", "está", "muy", "también", "porque"},
+	"fi": {"ja", "on", "ei", "se", "että", "hän", "oli", "ole", "kun", "mutta", "tai", "niin", "kuin", "ovat", "myös", "vain", "jos", "sen", "mitä", "tämä"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "que", "dans", "pour", "qui", "pas", "sur", "au", "avec", "ce", "sont", "du", "mais", "nous", "vous", "être", "très"},
+	"it": {"il", "di", "che", "la", "per", "non", "una", "sono", "gli", "della", "con", "anche", "questo", "ma", "come", "del", "nel", "è", "più", "alla", "perché"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "te", "zijn", "voor", "met", "ook", "maar", "wordt", "naar", "bij", "deze", "geen", "nog"},
+	"no": {"og", "jeg", "det", "ikke", "til", "av", "som", "på", "med", "har", "er", "meg", "hadde", "hva", "hvor", "etter", "også", "bare", "være", "når", "ble"},
+	"pt": {"o", "a", "os", "que", "de", "não", "uma", "para", "com", "por", "mais", "as", "dos", "como", "mas", "foi", "ao", "das", "está", "também", "são", "você"},
+	"sv": {"och", "att", "det", "som", "en", "på", "är", "av", "för", "med", "till", "den", "har", "inte", "om", "ett", "men", "var", "jag", "också", "när"},
+}
+
+// stopWordSets indexes stopWords for lookups
+var stopWordSets = func() map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(stopWords))
+	for lang, words := range stopWords {
+		set := make(map[string]bool, len(words))
+		for _, word := range words {
+			set[word] = true
+		}
+		sets[lang] = set
+	}
+	return sets
+}()
+
+// minStopWords is the number of stop words a text needs before its language
+// is trusted; shorter texts are left undetected
+const minStopWords = 2
+
+// DetectLanguage guesses which of the candidate languages text is written in
+// and returns its code, or an empty string if none fits clearly. Scripts
+// decide CJK and Russian; Latin-script languages are told apart by their stop
+// words.
+func DetectLanguage(text string, candidates []string) string {
+	allowed := make(map[string]bool, len(candidates))
+	for _, lang := range candidates {
+		allowed[lang] = true
+	}
+
+	var letters, cjk, cyrillic int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// CJK characters are whole words or syllables, so they count for more
+	// than their share of letters suggests
+	if allowed[LanguageCJK] && cjk*3 >= letters {
+		return LanguageCJK
+	}
+	if allowed["ru"] && cyrillic*2 >= letters {
+		return "ru"
+	}
+
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for lang, set := range stopWordSets {
+			if allowed[lang] && set[word] {
+				counts[lang]++
+			}
+		}
+	}
+
+	best, bestCount, runnerUp := "", 0, 0
+	for _, lang := range candidates {
+		count := counts[lang]
+		switch {
+		case count > bestCount:
+			best, bestCount, runnerUp = lang, count, bestCount
+		case count > runnerUp:
+			runnerUp = count
+		}
+	}
+	if bestCount < minStopWords || bestCount == runnerUp {
+		return ""
+	}
+	return best
+}
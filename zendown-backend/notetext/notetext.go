@@ -0,0 +1,177 @@
+package notetext
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// inlineTags do not separate words, so no space is inserted at their
+// boundaries. Every other tag is treated as a block boundary.
+var inlineTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "del": true, "em": true,
+	"i": true, "ins": true, "kbd": true, "mark": true, "s": true, "small": true,
+	"span": true, "strong": true, "sub": true, "sup": true, "u": true,
+}
+
+// inlineMath matches $...$ math written directly in the text, which the
+// editor renders with KaTeX
+var inlineMath = regexp.MustCompile(`\$([^$\n]+)\$`)
+
+// Sections is the searchable text of a note, split by where it appears
+type Sections struct {
+	// Text is the visible prose, including inline code
+	Text string
+	// Callouts is the text inside callout blocks
+	Callouts string
+	// Code is the content of code blocks
+	Code string
+	// LaTeX is the source of block and inline equations, without delimiters
+	LaTeX string
+}
+
+type extractor struct {
+	text, callouts, code, latex strings.Builder
+}
+
+// Extract walks note HTML and sorts its text into sections. Markup, class
+// names and attribute values never end up in the text, except for equation
+// source kept in data-content attributes.
+func Extract(content string) Sections {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		// The parser only fails on read errors, which a string cannot have
+		return Sections{}
+	}
+
+	e := &extractor{}
+	e.walk(doc, &e.text)
+
+	return Sections{
+		Text:     collapse(e.text.String()),
+		Callouts: collapse(e.callouts.String()),
+		Code:     collapse(e.code.String()),
+		LaTeX:    collapse(e.latex.String()),
+	}
+}
+
+// Plain returns all readable text of note HTML, that is everything but
+// equation source, with whitespace collapsed
+func Plain(content string) string {
+	sections := Extract(content)
+
+	var parts []string
+	for _, part := range []string{sections.Text, sections.Callouts, sections.Code} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// walk appends the text below n to out, diverting special blocks to their
+// own sections
+func (e *extractor) walk(n *html.Node, out *strings.Builder) {
+	switch n.Type {
+	case html.TextNode:
+		e.writeText(n.Data, out)
+		return
+	case html.ElementNode:
+		switch {
+		case n.Data == "script" || n.Data == "style" || n.Data == "template":
+			return
+		case hasClass(n, "block-equation") || attr(n, "data-block-equation") != "":
+			e.latex.WriteString(stripMathDelimiters(attr(n, "data-content")))
+			e.latex.WriteByte(' ')
+			return
+		case hasClass(n, "inline-equation"):
+			e.latex.WriteString(stripMathDelimiters(textOf(n)))
+			e.latex.WriteByte(' ')
+			return
+		case n.Data == "pre":
+			e.code.WriteString(textOf(n))
+			e.code.WriteByte(' ')
+			return
+		case hasClass(n, "callout") || attr(n, "data-callout") != "":
+			out = &e.callouts
+		case n.Data == "img":
+			out.WriteString(" " + attr(n, "alt") + " ")
+			return
+		}
+
+		if !inlineTags[n.Data] {
+			out.WriteByte(' ')
+		}
+		defer func() {
+			if !inlineTags[n.Data] {
+				out.WriteByte(' ')
+			}
+		}()
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		e.walk(child, out)
+	}
+}
+
+// writeText appends a text node, moving $...$ math to the LaTeX section
+func (e *extractor) writeText(text string, out *strings.Builder) {
+	last := 0
+	for _, m := range inlineMath.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(text[last:m[0]])
+		out.WriteByte(' ')
+		e.latex.WriteString(text[m[2]:m[3]])
+		e.latex.WriteByte(' ')
+		last = m[1]
+	}
+	out.WriteString(text[last:])
+}
+
+// textOf returns the raw text below n
+func textOf(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func stripMathDelimiters(latex string) string {
+	latex = strings.TrimSpace(latex)
+	for _, delim := range []string{"$$", "$"} {
+		if len(latex) >= 2*len(delim) && strings.HasPrefix(latex, delim) && strings.HasSuffix(latex, delim) {
+			return strings.TrimSpace(latex[len(delim) : len(latex)-len(delim)])
+		}
+	}
+	return latex
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,243 @@
+package outbox
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"zendown/database"
+)
+
+// Target applies outbox jobs to one index
+type Target interface {
+	Upsert(note *database.Note) error
+	Delete(noteID int64) error
+}
+
+type Options struct {
+	// Workers is the number of jobs processed concurrently. Jobs for the same
+	// note always go to the same worker so they run in order.
+	Workers int
+	// PollInterval is how often the outbox is checked when nobody calls Notify
+	PollInterval time.Duration
+	// MaxAttempts is the number of tries before a job is marked failed
+	MaxAttempts int
+	// BaseBackoff is the delay after the first failure; it doubles per attempt
+	BaseBackoff time.Duration
+	// MaxBackoff caps the retry delay
+	MaxBackoff time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Workers:      4,
+		PollInterval: 5 * time.Second,
+		MaxAttempts:  10,
+		BaseBackoff:  2 * time.Second,
+		MaxBackoff:   10 * time.Minute,
+	}
+}
+
+// Worker drains the index outbox, retrying failed jobs with exponential backoff
+type Worker struct {
+	db      *database.DB
+	targets map[string]Target
+	opts    Options
+
+	wake     chan struct{}
+	shards   []chan *database.IndexJob
+	mu       sync.Mutex
+	inFlight map[int64]bool
+}
+
+func NewWorker(db *database.DB, targets map[string]Target, opts Options) *Worker {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	return &Worker{
+		db:       db,
+		targets:  targets,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		inFlight: make(map[int64]bool),
+	}
+}
+
+// Start launches the dispatcher and the worker pool
+func (w *Worker) Start() {
+	for i := 0; i < w.opts.Workers; i++ {
+		shard := make(chan *database.IndexJob, 64)
+		w.shards = append(w.shards, shard)
+		go w.run(shard)
+	}
+
+	go w.dispatch()
+}
+
+// Notify wakes the dispatcher so newly queued jobs run without waiting for
+// the next poll
+func (w *Worker) Notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Drain synchronously applies every job that is ready now, trying each job at
+// most once. It is meant for one-off commands that run without the worker pool
+// and returns the number of jobs attempted.
+func (w *Worker) Drain() int {
+	seen := make(map[int64]bool)
+	for {
+		jobs, err := w.db.GetReadyIndexJobs(100)
+		if err != nil {
+			log.Printf("Failed to read index outbox: %v", err)
+			return len(seen)
+		}
+
+		progressed := false
+		for _, job := range jobs {
+			if seen[job.ID] {
+				continue
+			}
+			seen[job.ID] = true
+			progressed = true
+			w.process(job)
+		}
+
+		if !progressed {
+			return len(seen)
+		}
+	}
+}
+
+func (w *Worker) dispatch() {
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		jobs, err := w.db.GetReadyIndexJobs(100)
+		if err != nil {
+			log.Printf("Failed to read index outbox: %v", err)
+		}
+
+		for _, job := range jobs {
+			w.mu.Lock()
+			busy := w.inFlight[job.ID]
+			if !busy {
+				w.inFlight[job.ID] = true
+			}
+			w.mu.Unlock()
+
+			if !busy {
+				w.shards[job.NoteID%int64(len(w.shards))] <- job
+			}
+		}
+
+		select {
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) run(jobs <-chan *database.IndexJob) {
+	for job := range jobs {
+		w.process(job)
+
+		w.mu.Lock()
+		delete(w.inFlight, job.ID)
+		w.mu.Unlock()
+
+		// Later jobs for the same note may have become ready
+		w.Notify()
+	}
+}
+
+func (w *Worker) process(job *database.IndexJob) {
+	if err := w.db.StartIndexJob(job.ID); err != nil {
+		log.Printf("Failed to start index job %d: %v", job.ID, err)
+		return
+	}
+	attempts := job.Attempts + 1
+
+	err := w.apply(job)
+	if err == nil {
+		if err := w.db.CompleteIndexJob(job.ID); err != nil {
+			log.Printf("Failed to complete index job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	giveUp := attempts >= w.opts.MaxAttempts
+	next := time.Now().Add(w.backoff(attempts))
+	if giveUp {
+		log.Printf("Giving up on %s %s of note %d after %d attempts: %v", job.Target, job.Op, job.NoteID, attempts, err)
+	} else {
+		log.Printf("Failed to %s note %d in %s (attempt %d), retrying at %s: %v", job.Op, job.NoteID, job.Target, attempts, next.Format(time.RFC3339), err)
+	}
+
+	if err := w.db.RetryIndexJobLater(job.ID, err, next, giveUp); err != nil {
+		log.Printf("Failed to reschedule index job %d: %v", job.ID, err)
+	}
+}
+
+func (w *Worker) apply(job *database.IndexJob) error {
+	target, ok := w.targets[job.Target]
+	if !ok {
+		// The index is disabled; there is nothing to keep in sync
+		return nil
+	}
+
+	switch job.Op {
+	case database.IndexOpUpsert:
+		note, err := w.db.GetNote(job.NoteID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The note was trashed or deleted since; a delete job follows
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := target.Upsert(note); err != nil {
+			return err
+		}
+		w.recordState(w.db.MarkIndexed(note.ID, job.Target, note.UpdatedAt))
+		return nil
+	case database.IndexOpDelete:
+		if err := target.Delete(job.NoteID); err != nil {
+			return err
+		}
+		w.recordState(w.db.MarkUnindexed(job.NoteID, job.Target))
+		return nil
+	default:
+		log.Printf("Dropping index job %d with unknown op %q", job.ID, job.Op)
+		return nil
+	}
+}
+
+// recordState logs a failure to update the index state. The index itself was
+// updated, so the job still succeeds; reconciliation repairs the state later.
+func (w *Worker) recordState(err error) {
+	if err != nil {
+		log.Printf("Failed to record index state: %v", err)
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling per failure
+// with up to 20% jitter so retries against a recovering service spread out
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.opts.BaseBackoff
+	for i := 1; i < attempts && delay < w.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > w.opts.MaxBackoff {
+		delay = w.opts.MaxBackoff
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -1,61 +1,177 @@
 package search
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"zendown/database"
+	"zendown/notetext"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/simple"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/highlight/highlighter/html"
 )
 
+// MappingVersion identifies the index mapping and the text extraction that
+// feeds it. Bump it whenever either changes; indexes built with another
+// version are rebuilt in the background on startup.
+const MappingVersion = 5
+
+// ErrIndexUnavailable is returned while a damaged index is being rebuilt
+var ErrIndexUnavailable = errors.New("search index is unavailable until it has been rebuilt")
+
+// mappingVersionKey is the internal index key holding the mapping version
+var mappingVersionKey = []byte("zendown_mapping_version")
+
+// languagesKey is the internal index key holding the language configuration
+var languagesKey = []byte("zendown_languages")
+
 type BM25SearchService struct {
-	index bleve.Index
+	path      string
+	languages LanguageConfig
+
+	// mu guards the serving index, which is swapped out after a rebuild
+	mu             sync.RWMutex
+	index          bleve.Index
+	servingVersion int
+	rebuildReason  string
+
+	rebuild rebuildState
 }
 
+// SearchDocument is what gets indexed for a note. Content holds the visible
+// text of the note; callouts, code blocks and equation source are kept in
+// their own fields so they neither dilute nor get lost in the prose.
 type SearchDocument struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// TitleSuggest repeats the title for the edge n-gram suggestion field
+	TitleSuggest string    `json:"title_suggest"`
+	Content      string    `json:"content"`
+	Callout      string    `json:"callout"`
+	Code         string    `json:"code"`
+	LaTeX        string    `json:"latex"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Language is the language the note was analyzed in, and Localized
+	// repeats its text under that language for the matching analyzer
+	Language  string                   `json:"language"`
+	Localized map[string]LocalizedText `json:"lang"`
+	// Collections and UpdatedMonth (YYYY-MM) are facet dimensions
+	Collections  []string `json:"collections"`
+	UpdatedMonth string   `json:"updated_month"`
 }
 
+// LocalizedText is the part of a note analyzed by language
+type LocalizedText struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Callout string `json:"callout"`
+}
+
+// BleveType selects the note document mapping
+func (d SearchDocument) BleveType() string {
+	return "note"
+}
+
+// textFields are the analyzed fields searched by unqualified query terms
+var textFields = []string{"title", "content", "callout", "code", "latex"}
+
+// SearchResult is a BM25 hit. Note carries the stored title and timestamps
+// but not the content. Fragments holds HTML-escaped excerpts per field with
+// matches wrapped in <mark>, and Locations the matched terms per field.
 type SearchResult struct {
-	Note  *database.Note `json:"note"`
-	Score float64        `json:"score"`
+	Note      *database.Note            `json:"note"`
+	Score     float64                   `json:"score"`
+	Fragments map[string][]string       `json:"fragments,omitempty"`
+	Locations map[string][]TermLocation `json:"locations,omitempty"`
+}
+
+// TermLocation is a matched term in a field. Start and End are byte offsets
+// into the indexed field text and Position is the term's ordinal.
+type TermLocation struct {
+	Term     string `json:"term"`
+	Start    uint64 `json:"start"`
+	End      uint64 `json:"end"`
+	Position uint64 `json:"position"`
 }
 
-func NewBM25SearchService(indexPath string) (*BM25SearchService, error) {
+// NewBM25SearchService opens the index at indexPath, creating it if missing.
+// An index built with an outdated mapping keeps serving until a rebuild
+// replaces it; a damaged one leaves search unavailable until then. Either
+// case is reported by RebuildReason, as is a change of languages.
+func NewBM25SearchService(indexPath string, languages LanguageConfig) (*BM25SearchService, error) {
 	// Create the index directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create index directory: %w", err)
 	}
 
-	var index bleve.Index
-	var err error
+	if err := recoverInterruptedSwap(indexPath); err != nil {
+		return nil, fmt.Errorf("failed to clean up after interrupted rebuild: %w", err)
+	}
+
+	s := &BM25SearchService{path: indexPath, languages: languages}
 
 	// Try to open existing index
-	index, err = bleve.Open(indexPath)
-	if err != nil {
+	index, err := bleve.Open(indexPath)
+	switch {
+	case err == nil:
+		version, verr := indexMappingVersion(index)
+		if verr != nil || version != MappingVersion {
+			log.Printf("BM25 index mapping version %d does not match %d, it will be rebuilt", version, MappingVersion)
+			s.rebuildReason = fmt.Sprintf("index mapping version %d is outdated", version)
+		} else if indexed, err := index.GetInternal(languagesKey); err != nil || string(indexed) != languages.String() {
+			log.Printf("BM25 index languages %q do not match %q, it will be rebuilt", indexed, languages.String())
+			s.rebuildReason = fmt.Sprintf("index languages changed from %q to %q", indexed, languages.String())
+		}
+		s.index = index
+		s.servingVersion = version
+	case errors.Is(err, bleve.ErrorIndexPathDoesNotExist):
 		// Index doesn't exist, create a new one
 		log.Printf("Creating new BM25 search index at: %s", indexPath)
-		index, err = createIndex(indexPath)
+		index, err = createIndex(indexPath, languages)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create index: %w", err)
 		}
+		s.index = index
+		s.servingVersion = MappingVersion
+	default:
+		log.Printf("BM25 index at %s could not be opened, it will be rebuilt: %v", indexPath, err)
+		s.rebuildReason = fmt.Sprintf("index could not be opened: %v", err)
 	}
 
-	return &BM25SearchService{
-		index: index,
-	}, nil
+	return s, nil
 }
 
-func createIndex(indexPath string) (bleve.Index, error) {
+// withIndex runs fn against the serving index, holding it open until fn returns
+func (s *BM25SearchService) withIndex(fn func(index bleve.Index) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.index == nil {
+		return ErrIndexUnavailable
+	}
+	return fn(s.index)
+}
+
+// RebuildReason explains why the index needs a rebuild, or is empty if the
+// serving index is current
+func (s *BM25SearchService) RebuildReason() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rebuildReason
+}
+
+func createIndex(indexPath string, languages LanguageConfig) (bleve.Index, error) {
 	// Create a new index mapping
 	indexMapping := bleve.NewIndexMapping()
 
@@ -72,12 +188,29 @@ func createIndex(indexPath string) (bleve.Index, error) {
 	titleFieldMapping.Index = true
 	noteMapping.AddFieldMappingsAt("title", titleFieldMapping)
 
-	// Content field - use standard analyzer for better search
-	contentFieldMapping := bleve.NewTextFieldMapping()
-	contentFieldMapping.Analyzer = "standard"
-	contentFieldMapping.Store = true
-	contentFieldMapping.Index = true
-	noteMapping.AddFieldMappingsAt("content", contentFieldMapping)
+	// Title prefixes for search-as-you-type suggestions
+	if err := addSuggestAnalyzer(indexMapping); err != nil {
+		return nil, err
+	}
+	suggestFieldMapping := bleve.NewTextFieldMapping()
+	suggestFieldMapping.Analyzer = suggestAnalyzer
+	suggestFieldMapping.Store = false
+	suggestFieldMapping.IncludeTermVectors = false
+	noteMapping.AddFieldMappingsAt("title_suggest", suggestFieldMapping)
+
+	// Content fields - visible text, callouts, code blocks and equations are
+	// indexed separately; all are stored so matches can be highlighted
+	for _, field := range []string{"content", "callout", "code", "latex"} {
+		fieldMapping := bleve.NewTextFieldMapping()
+		fieldMapping.Analyzer = "standard"
+		if field == "code" {
+			// Split identifiers like fmt.Println on punctuation
+			fieldMapping.Analyzer = simple.Name
+		}
+		fieldMapping.Store = true
+		fieldMapping.Index = true
+		noteMapping.AddFieldMappingsAt(field, fieldMapping)
+	}
 
 	// ID field - use keyword analyzer for exact matches
 	idFieldMapping := bleve.NewTextFieldMapping()
@@ -97,59 +230,171 @@ func createIndex(indexPath string) (bleve.Index, error) {
 	updatedAtFieldMapping.Index = true
 	noteMapping.AddFieldMappingsAt("updated_at", updatedAtFieldMapping)
 
+	// Language-specific copies of the prose, each with the analyzer of its
+	// language so words are stemmed and segmented properly
+	languageFieldMapping := bleve.NewKeywordFieldMapping()
+	languageFieldMapping.Store = true
+	noteMapping.AddFieldMappingsAt("language", languageFieldMapping)
+
+	localizedMapping := bleve.NewDocumentStaticMapping()
+	for _, lang := range languages.Languages {
+		langMapping := bleve.NewDocumentStaticMapping()
+		for _, field := range localizedFields {
+			fieldMapping := bleve.NewTextFieldMapping()
+			fieldMapping.Analyzer = lang
+			fieldMapping.Store = true
+			fieldMapping.Index = true
+			langMapping.AddFieldMappingsAt(field, fieldMapping)
+		}
+		localizedMapping.AddSubDocumentMapping(lang, langMapping)
+	}
+	noteMapping.AddSubDocumentMapping("lang", localizedMapping)
+
+	// Facet dimensions, kept verbatim
+	for _, field := range []string{"collections", "updated_month"} {
+		noteMapping.AddFieldMappingsAt(field, bleve.NewKeywordFieldMapping())
+	}
+
+	// Only the fields above are indexed
+	noteMapping.Dynamic = false
+
 	// Add the note mapping to the index
 	indexMapping.AddDocumentMapping("note", noteMapping)
 
-	// Create the index
-	return bleve.New(indexPath, indexMapping)
+	// Create the index and record which mapping it was built with
+	index, err := bleve.New(indexPath, indexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := index.SetInternal(mappingVersionKey, []byte(strconv.Itoa(MappingVersion))); err != nil {
+		index.Close()
+		return nil, err
+	}
+	if err := index.SetInternal(languagesKey, []byte(languages.String())); err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	return index, nil
 }
 
-func (s *BM25SearchService) IndexNote(note *database.Note) error {
+// indexMappingVersion reads the mapping version an index was built with.
+// Indexes created before versioning report version 0.
+func indexMappingVersion(index bleve.Index) (int, error) {
+	value, err := index.GetInternal(mappingVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(value))
+}
+
+// IndexNote adds or replaces a note, together with the names of the
+// collections it belongs to
+func (s *BM25SearchService) IndexNote(note *database.Note, collections []string) error {
+	doc := s.searchDocument(note, collections)
+	return s.withIndex(func(index bleve.Index) error {
+		return index.Index(doc.ID, doc)
+	})
+}
+
+// searchDocument builds the indexed form of a note. The extracted text is
+// indexed rather than the HTML so markup neither matches queries nor shows
+// up in highlighted fragments.
+func (s *BM25SearchService) searchDocument(note *database.Note, collections []string) SearchDocument {
+	sections := notetext.Extract(note.Content)
 	doc := SearchDocument{
-		ID:        fmt.Sprintf("%d", note.ID),
-		Title:     note.Title,
-		Content:   note.Content,
-		CreatedAt: note.CreatedAt,
-		UpdatedAt: note.UpdatedAt,
+		ID:           fmt.Sprintf("%d", note.ID),
+		Title:        note.Title,
+		TitleSuggest: note.Title,
+		Content:      sections.Text,
+		Callout:      sections.Callouts,
+		Code:         sections.Code,
+		LaTeX:        sections.LaTeX,
+		CreatedAt:    note.CreatedAt,
+		UpdatedAt:    note.UpdatedAt,
 	}
 
-	return s.index.Index(doc.ID, doc)
+	doc.Collections = collections
+	doc.UpdatedMonth = note.UpdatedAt.UTC().Format(monthLayout)
+
+	doc.Language = s.languages.Resolve(note, sections.Text)
+	doc.Localized = map[string]LocalizedText{
+		doc.Language: {Title: doc.Title, Content: doc.Content, Callout: doc.Callout},
+	}
+
+	return doc
 }
 
 func (s *BM25SearchService) RemoveNote(noteID int64) error {
 	docID := fmt.Sprintf("%d", noteID)
-	return s.index.Delete(docID)
+	return s.withIndex(func(index bleve.Index) error {
+		return index.Delete(docID)
+	})
 }
 
-func (s *BM25SearchService) Search(query string, limit int) ([]SearchResult, error) {
-	if strings.TrimSpace(query) == "" {
-		return []SearchResult{}, nil
-	}
-
-	// Create a search query that searches both title and content
-	titleQuery := bleve.NewMatchQuery(query)
-	titleQuery.SetField("title")
-	titleQuery.SetBoost(2.0) // Give title matches higher weight
+// SearchOptions controls what a search returns
+type SearchOptions struct {
+	// Limit is the maximum number of hits and Offset the number of best hits
+	// skipped before them
+	Limit  int
+	Offset int
+	// FacetSize is the number of buckets per facet; 0 skips facets
+	FacetSize int
+}
 
-	contentQuery := bleve.NewMatchQuery(query)
-	contentQuery.SetField("content")
+// SearchResults are the hits of a search, best first, and the facets of
+// all matches when requested
+type SearchResults struct {
+	Hits   []SearchResult
+	Total  uint64
+	Facets *Facets
+}
 
-	// Combine queries with OR
-	searchQuery := bleve.NewDisjunctionQuery(titleQuery, contentQuery)
+// Search runs a parsed query; see Query for the syntax. Collection filters
+// must have been resolved with Query.ResolveCollections.
+func (s *BM25SearchService) Search(q *Query, opts SearchOptions) (*SearchResults, error) {
+	searchQuery := q.bleveQuery(s.languages.Languages)
 
-	// Create search request
+	// Create search request. Only metadata is loaded; the content is
+	// represented by highlighted fragments.
 	searchRequest := bleve.NewSearchRequest(searchQuery)
-	searchRequest.Size = limit
-	searchRequest.Fields = []string{"*"} // Return all fields
+	searchRequest.Size = opts.Limit
+	searchRequest.From = opts.Offset
+	searchRequest.Fields = []string{"title", "created_at", "updated_at"}
+	searchRequest.Highlight = bleve.NewHighlightWithStyle(html.Name)
+	for _, field := range textFields {
+		searchRequest.Highlight.AddField(field)
+	}
+	for _, lang := range s.languages.Languages {
+		for _, field := range localizedFields {
+			searchRequest.Highlight.AddField(localizedField(lang, field))
+		}
+	}
+	searchRequest.IncludeLocations = true
+	if opts.FacetSize > 0 {
+		addFacetRequests(searchRequest, opts.FacetSize)
+	}
 
 	// Execute search
-	searchResult, err := s.index.Search(searchRequest)
+	var searchResult *bleve.SearchResult
+	err := s.withIndex(func(index bleve.Index) error {
+		var err error
+		searchResult, err = index.Search(searchRequest)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("search failed: %w", err)
 	}
 
 	// Convert results
-	var results []SearchResult
+	results := &SearchResults{Total: searchResult.Total}
+	if opts.FacetSize > 0 {
+		results.Facets = facetsFromResult(searchResult.Facets)
+	}
 	for _, hit := range searchResult.Hits {
 		noteID, err := parseNoteID(hit.ID)
 		if err != nil {
@@ -161,29 +406,174 @@ func (s *BM25SearchService) Search(query string, limit int) ([]SearchResult, err
 		note := &database.Note{
 			ID:        noteID,
 			Title:     getStringField(hit.Fields, "title"),
-			Content:   getStringField(hit.Fields, "content"),
 			CreatedAt: getTimeField(hit.Fields, "created_at"),
 			UpdatedAt: getTimeField(hit.Fields, "updated_at"),
 		}
 
-		results = append(results, SearchResult{
-			Note:  note,
-			Score: hit.Score,
+		results.Hits = append(results.Hits, SearchResult{
+			Note:      note,
+			Score:     hit.Score,
+			Fragments: matchedFragments(hit.Fragments, hit.Locations),
+			Locations: termLocations(hit.Locations),
 		})
 	}
 
 	return results, nil
 }
 
+// MatchingNotes returns which of noteIDs match q. Only matching counts, so
+// nothing is scored or loaded.
+func (s *BM25SearchService) MatchingNotes(q *Query, noteIDs []int64) (map[int64]bool, error) {
+	ids := make([]string, 0, len(noteIDs))
+	for _, id := range noteIDs {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+
+	searchRequest := bleve.NewSearchRequest(bleve.NewConjunctionQuery(q.bleveQuery(s.languages.Languages), bleve.NewDocIDQuery(ids)))
+	searchRequest.Size = len(ids)
+	searchRequest.Score = "none"
+
+	var searchResult *bleve.SearchResult
+	err := s.withIndex(func(index bleve.Index) error {
+		var err error
+		searchResult, err = index.Search(searchRequest)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	matching := make(map[int64]bool, len(searchResult.Hits))
+	for _, hit := range searchResult.Hits {
+		if noteID, err := parseNoteID(hit.ID); err == nil {
+			matching[noteID] = true
+		}
+	}
+	return matching, nil
+}
+
+// IndexedNotes returns the stored updated_at of every document in the index,
+// keyed by note ID
+func (s *BM25SearchService) IndexedNotes() (map[int64]time.Time, error) {
+	const pageSize = 1000
+
+	indexed := make(map[int64]time.Time)
+	var after []string
+	for {
+		searchRequest := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+		searchRequest.Size = pageSize
+		searchRequest.Fields = []string{"updated_at"}
+		searchRequest.SortBy([]string{"_id"})
+		if after != nil {
+			searchRequest.SearchAfter = after
+		}
+
+		var searchResult *bleve.SearchResult
+		err := s.withIndex(func(index bleve.Index) error {
+			var err error
+			searchResult, err = index.Search(searchRequest)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list indexed documents: %w", err)
+		}
+
+		for _, hit := range searchResult.Hits {
+			noteID, err := parseNoteID(hit.ID)
+			if err != nil {
+				log.Printf("Failed to parse note ID from indexed document: %s", hit.ID)
+				continue
+			}
+			indexed[noteID] = getTimeField(hit.Fields, "updated_at")
+		}
+
+		if len(searchResult.Hits) < pageSize {
+			return indexed, nil
+		}
+		after = []string{searchResult.Hits[len(searchResult.Hits)-1].ID}
+	}
+}
+
 func (s *BM25SearchService) Close() error {
-	return s.index.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.index == nil {
+		return nil
+	}
+	err := s.index.Close()
+	s.index = nil
+	return err
 }
 
 func (s *BM25SearchService) GetDocumentCount() (uint64, error) {
-	return s.index.DocCount()
+	var count uint64
+	err := s.withIndex(func(index bleve.Index) error {
+		var err error
+		count, err = index.DocCount()
+		return err
+	})
+	return count, err
 }
 
 // Helper functions
+
+// matchedFragments keeps the fragments of fields that actually matched.
+// Localized fields hold the same text as the field they mirror, so their
+// fragments are reported under that field when it has none of its own, for
+// example when only a stemmed form matched.
+func matchedFragments(fragments search.FieldFragmentMap, locations search.FieldTermLocationMap) map[string][]string {
+	result := make(map[string][]string)
+	for field, fieldFragments := range fragments {
+		if field == baseField(field) && len(locations[field]) > 0 {
+			result[field] = fieldFragments
+		}
+	}
+	for field, fieldFragments := range fragments {
+		base := baseField(field)
+		if field != base && len(locations[field]) > 0 && result[base] == nil {
+			result[base] = fieldFragments
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// termLocations lists the matched terms per field in text order. Matches in
+// localized fields are reported under the field they mirror unless it
+// matched itself, since offsets into the same text would otherwise repeat.
+func termLocations(locations search.FieldTermLocationMap) map[string][]TermLocation {
+	if len(locations) == 0 {
+		return nil
+	}
+
+	result := make(map[string][]TermLocation)
+	for field, terms := range locations {
+		base := baseField(field)
+		if field != base && len(locations[base]) > 0 {
+			continue
+		}
+		for term, locs := range terms {
+			for _, loc := range locs {
+				result[base] = append(result[base], TermLocation{
+					Term:     term,
+					Start:    loc.Start,
+					End:      loc.End,
+					Position: loc.Pos,
+				})
+			}
+		}
+	}
+	for field := range result {
+		sort.Slice(result[field], func(i, j int) bool {
+			return result[field][i].Start < result[field][j].Start
+		})
+	}
+	return result
+}
+
 func parseNoteID(id string) (int64, error) {
 	var noteID int64
 	_, err := fmt.Sscanf(id, "%d", &noteID)
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"sort"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// Facet names in search requests and responses
+const (
+	facetCollections = "collections"
+	facetMonths      = "months"
+)
+
+// FacetCount is the number of matches sharing one facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets break the matches of a search down for drill-down filters. Months
+// are YYYY-MM of updated_at in UTC, newest first; collections are ordered by
+// count. Other counts matches in values beyond the returned buckets, and
+// Missing matches without any value, such as notes in no collection.
+type Facets struct {
+	Collections      []FacetCount `json:"collections"`
+	Months           []FacetCount `json:"months"`
+	OtherCollections int          `json:"other_collections"`
+	NoCollection     int          `json:"no_collection"`
+}
+
+func addFacetRequests(searchRequest *bleve.SearchRequest, size int) {
+	searchRequest.AddFacet(facetCollections, bleve.NewFacetRequest("collections", size))
+	searchRequest.AddFacet(facetMonths, bleve.NewFacetRequest("updated_month", size))
+}
+
+func facetsFromResult(results search.FacetResults) *Facets {
+	facets := &Facets{Collections: []FacetCount{}, Months: []FacetCount{}}
+
+	if result, ok := results[facetCollections]; ok {
+		facets.Collections = facetCounts(result)
+		facets.OtherCollections = result.Other
+		facets.NoCollection = result.Missing
+	}
+
+	if result, ok := results[facetMonths]; ok {
+		facets.Months = facetCounts(result)
+		sort.Slice(facets.Months, func(i, j int) bool {
+			return facets.Months[i].Value > facets.Months[j].Value
+		})
+	}
+
+	return facets
+}
+
+// FacetsOf counts facets over notes directly, for results that did not come
+// from the Bleve index
+func FacetsOf(notes []IndexedNote, size int) *Facets {
+	facets := &Facets{}
+
+	collections := make(map[string]int)
+	months := make(map[string]int)
+	for _, note := range notes {
+		if len(note.Collections) == 0 {
+			facets.NoCollection++
+		}
+		for _, name := range note.Collections {
+			collections[name]++
+		}
+		months[note.Note.UpdatedAt.UTC().Format(monthLayout)]++
+	}
+
+	facets.Collections, facets.OtherCollections = topCounts(collections, size, func(a, b FacetCount) bool {
+		return a.Count > b.Count || (a.Count == b.Count && a.Value < b.Value)
+	})
+	facets.Months, _ = topCounts(months, size, func(a, b FacetCount) bool {
+		return a.Count > b.Count || (a.Count == b.Count && a.Value > b.Value)
+	})
+	sort.Slice(facets.Months, func(i, j int) bool {
+		return facets.Months[i].Value > facets.Months[j].Value
+	})
+
+	return facets
+}
+
+// topCounts returns the size largest counts by less and the sum of the rest
+func topCounts(counts map[string]int, size int, less func(a, b FacetCount) bool) ([]FacetCount, int) {
+	result := []FacetCount{}
+	for value, count := range counts {
+		result = append(result, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool { return less(result[i], result[j]) })
+
+	other := 0
+	if len(result) > size {
+		for _, count := range result[size:] {
+			other += count.Count
+		}
+		result = result[:size]
+	}
+	return result, other
+}
+
+func facetCounts(result *search.FacetResult) []FacetCount {
+	counts := []FacetCount{}
+	if result.Terms == nil {
+		return counts
+	}
+	for _, term := range result.Terms.Terms() {
+		counts = append(counts, FacetCount{Value: term.Term, Count: term.Count})
+	}
+	return counts
+}
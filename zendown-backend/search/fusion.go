@@ -0,0 +1,114 @@
+package search
+
+import (
+	"sort"
+)
+
+// Fusion methods for combining rankings from several backends
+const (
+	// FusionRRF is reciprocal rank fusion, which only looks at positions
+	FusionRRF = "rrf"
+	// FusionWeighted sums min-max normalized scores
+	FusionWeighted = "weighted"
+)
+
+// DefaultRRFK dampens the weight of top ranks in reciprocal rank fusion
+const DefaultRRFK = 60
+
+// RankedHit is one note in a backend's ranking, best first
+type RankedHit struct {
+	NoteID int64
+	Score  float64
+}
+
+// FusedHit is a note in the combined ranking together with where it came from
+type FusedHit struct {
+	NoteID int64
+	Score  float64
+	// Sources lists the backends that returned the note, in backend order
+	Sources []string
+	// Ranks and Scores hold the 1-based rank and raw score per backend
+	Ranks  map[string]int
+	Scores map[string]float64
+}
+
+// FuseRRF merges rankings with reciprocal rank fusion: every backend adds
+// weight / (k + rank) for each note it returned. Backends are given in
+// order, which also decides the order of FusedHit.Sources.
+func FuseRRF(backends []string, rankings map[string][]RankedHit, weights map[string]float64, k float64) []FusedHit {
+	return fuse(backends, rankings, func(backend string, rank int) float64 {
+		return weights[backend] / (k + float64(rank))
+	})
+}
+
+// FuseWeighted merges rankings by a weighted sum of scores normalized to
+// [0, 1] per backend, so backends with different score scales compare
+func FuseWeighted(backends []string, rankings map[string][]RankedHit, weights map[string]float64) []FusedHit {
+	normalized := make(map[string][]float64)
+	for backend, hits := range rankings {
+		if len(hits) == 0 {
+			continue
+		}
+
+		min, max := hits[0].Score, hits[0].Score
+		for _, hit := range hits {
+			if hit.Score < min {
+				min = hit.Score
+			}
+			if hit.Score > max {
+				max = hit.Score
+			}
+		}
+
+		scores := make([]float64, len(hits))
+		for i, hit := range hits {
+			scores[i] = 1
+			if max > min {
+				scores[i] = (hit.Score - min) / (max - min)
+			}
+		}
+		normalized[backend] = scores
+	}
+
+	return fuse(backends, rankings, func(backend string, rank int) float64 {
+		return weights[backend] * normalized[backend][rank-1]
+	})
+}
+
+func fuse(backends []string, rankings map[string][]RankedHit, contribution func(backend string, rank int) float64) []FusedHit {
+	fused := make(map[int64]*FusedHit)
+	for _, backend := range backends {
+		hits := rankings[backend]
+		for i, hit := range hits {
+			f, ok := fused[hit.NoteID]
+			if !ok {
+				f = &FusedHit{
+					NoteID: hit.NoteID,
+					Ranks:  make(map[string]int),
+					Scores: make(map[string]float64),
+				}
+				fused[hit.NoteID] = f
+			}
+			if _, seen := f.Ranks[backend]; seen {
+				continue
+			}
+
+			f.Sources = append(f.Sources, backend)
+			f.Ranks[backend] = i + 1
+			f.Scores[backend] = hit.Score
+			f.Score += contribution(backend, i+1)
+		}
+	}
+
+	results := make([]FusedHit, 0, len(fused))
+	for _, f := range fused {
+		results = append(results, *f)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].NoteID < results[j].NoteID
+	})
+	return results
+}
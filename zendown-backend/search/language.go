@@ -0,0 +1,119 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"zendown/database"
+	"zendown/notetext"
+
+	// Register the language analyzers used by the localized fields
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/da"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/de"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/fi"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/it"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/nl"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/no"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/pt"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/ru"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/sv"
+)
+
+// SupportedLanguages are the language codes that have an analyzer and can be
+// detected. Each code is also the name of its Bleve analyzer.
+var SupportedLanguages = []string{"cjk", "da", "de", "en", "es", "fi", "fr", "it", "nl", "no", "pt", "ru", "sv"}
+
+// localizedFields are the text fields that are also indexed with the
+// analyzer of the note's language
+var localizedFields = []string{"title", "content", "callout"}
+
+// LanguageConfig selects the languages the index analyzes. Notes in any
+// other language, or whose language cannot be detected, use Default.
+type LanguageConfig struct {
+	Languages []string
+	Default   string
+}
+
+// DefaultLanguageConfig covers the most common European languages and CJK
+func DefaultLanguageConfig() LanguageConfig {
+	return LanguageConfig{
+		Languages: []string{"en", "de", "fr", "es", "it", "nl", "pt", "ru", "cjk"},
+		Default:   "en",
+	}
+}
+
+// ParseLanguageConfig builds a config from a comma-separated list of language
+// codes and the default language. Empty values keep the defaults; the default
+// language is added to the list if missing.
+func ParseLanguageConfig(languages, defaultLanguage string) (LanguageConfig, error) {
+	config := DefaultLanguageConfig()
+
+	if languages != "" {
+		config.Languages = nil
+		for _, lang := range strings.Split(languages, ",") {
+			lang = strings.ToLower(strings.TrimSpace(lang))
+			if lang == "" || slices.Contains(config.Languages, lang) {
+				continue
+			}
+			if !slices.Contains(SupportedLanguages, lang) {
+				return config, fmt.Errorf("unsupported language %q, supported are %s", lang, strings.Join(SupportedLanguages, ", "))
+			}
+			config.Languages = append(config.Languages, lang)
+		}
+	}
+
+	if defaultLanguage != "" {
+		config.Default = strings.ToLower(strings.TrimSpace(defaultLanguage))
+		if !slices.Contains(SupportedLanguages, config.Default) {
+			return config, fmt.Errorf("unsupported default language %q, supported are %s", config.Default, strings.Join(SupportedLanguages, ", "))
+		}
+	}
+	if !slices.Contains(config.Languages, config.Default) {
+		config.Languages = append(config.Languages, config.Default)
+	}
+
+	return config, nil
+}
+
+// Supports reports whether lang is one of the configured languages
+func (c LanguageConfig) Supports(lang string) bool {
+	return slices.Contains(c.Languages, lang)
+}
+
+// Resolve picks the language a note is indexed in: its own language if set
+// and configured, else the detected one, else the default
+func (c LanguageConfig) Resolve(note *database.Note, text string) string {
+	if c.Supports(note.Language) {
+		return note.Language
+	}
+	if lang := notetext.DetectLanguage(note.Title+" "+text, c.Languages); lang != "" {
+		return lang
+	}
+	return c.Default
+}
+
+// String is the form stored in the index to detect configuration changes.
+// The order of the languages does not matter.
+func (c LanguageConfig) String() string {
+	languages := slices.Clone(c.Languages)
+	slices.Sort(languages)
+	return strings.Join(languages, ",") + ";default=" + c.Default
+}
+
+// localizedField is the path of field as analyzed for lang
+func localizedField(lang, field string) string {
+	return "lang." + lang + "." + field
+}
+
+// baseField maps a localized field path back to the field it mirrors
+func baseField(field string) string {
+	if strings.HasPrefix(field, "lang.") {
+		return field[strings.LastIndex(field, ".")+1:]
+	}
+	return field
+}
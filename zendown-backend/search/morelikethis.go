@@ -0,0 +1,213 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"zendown/database"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// More like this describes a note by its most distinctive terms and ranks
+// the notes sharing them
+const (
+	mltMaxTerms      = 25
+	mltMinTermLength = 3
+	// mltMaxDocShare drops terms found in more than this share of the notes,
+	// which says little about a note; it only applies from mltMinCorpus notes
+	mltMaxDocShare = 0.5
+	mltMinCorpus   = 10
+	// Title words say more about a note than words of its body
+	mltTitleWeight = 2
+)
+
+// mltFields are the fields whose terms describe a note. They share the
+// standard analyzer, so a term means the same in all of them.
+var mltFields = []string{"title", "content", "callout"}
+
+// MoreLikeThis finds notes that are lexically similar to note, best first.
+// Scores are relative to how well note matches its own terms, so they fall
+// between 0 and 1 like semantic similarities. A disjunction scales its score
+// by the share of clauses matched, so a note sharing half the weighted terms
+// gets about a quarter of the score; the square root of the ratio is used so
+// that thresholds meant for semantic scores work here too.
+func (s *BM25SearchService) MoreLikeThis(note *database.Note, limit int) ([]RankedHit, error) {
+	var hits []RankedHit
+	err := s.withIndex(func(idx bleve.Index) error {
+		terms, err := s.distinctiveTerms(idx, note)
+		if err != nil {
+			return err
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+
+		disjunction := bleve.NewDisjunctionQuery()
+		for _, term := range terms {
+			for _, field := range mltFields {
+				termQuery := bleve.NewTermQuery(term.text)
+				termQuery.SetField(field)
+				termQuery.SetBoost(term.weight)
+				disjunction.AddQuery(termQuery)
+			}
+		}
+
+		// The note itself is searched too, as its score scales the others
+		searchRequest := bleve.NewSearchRequest(disjunction)
+		searchRequest.Size = limit + 1
+		result, err := idx.Search(searchRequest)
+		if err != nil {
+			return fmt.Errorf("more like this search failed: %w", err)
+		}
+
+		sourceID := strconv.FormatInt(note.ID, 10)
+		maxScore := result.MaxScore
+		if self, err := idx.Search(selfRequest(disjunction, sourceID)); err == nil && len(self.Hits) > 0 {
+			maxScore = math.Max(maxScore, self.Hits[0].Score)
+		}
+		if maxScore <= 0 {
+			return nil
+		}
+
+		for _, hit := range result.Hits {
+			if hit.ID == sourceID {
+				continue
+			}
+			noteID, err := parseNoteID(hit.ID)
+			if err != nil {
+				continue
+			}
+			hits = append(hits, RankedHit{NoteID: noteID, Score: math.Sqrt(hit.Score / maxScore)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// selfRequest scores only the source note against the query
+func selfRequest(q query.Query, docID string) *bleve.SearchRequest {
+	return bleve.NewSearchRequest(bleve.NewConjunctionQuery(q, bleve.NewDocIDQuery([]string{docID})))
+}
+
+type weightedTerm struct {
+	text   string
+	weight float64
+}
+
+// distinctiveTerms weighs the terms of note by TF-IDF against the index and
+// returns the heaviest ones. Terms no other note has cannot match anything
+// and are left out.
+func (s *BM25SearchService) distinctiveTerms(idx bleve.Index, note *database.Note) ([]weightedTerm, error) {
+	doc := s.searchDocument(note, nil)
+
+	analyzer := idx.Mapping().AnalyzerNamed("standard")
+	if analyzer == nil {
+		return nil, fmt.Errorf("standard analyzer is not registered")
+	}
+
+	counts := make(map[string]float64)
+	for _, field := range []struct {
+		text   string
+		weight float64
+	}{
+		{doc.Title, mltTitleWeight},
+		{doc.Content, 1},
+		{doc.Callout, 1},
+	} {
+		for _, token := range analyzer.Analyze([]byte(field.text)) {
+			term := string(token.Term)
+			if !mltCandidate(term) {
+				continue
+			}
+			counts[term] += field.weight
+		}
+	}
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	advanced, err := idx.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	total, err := reader.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make([]weightedTerm, 0, len(counts))
+	for term, count := range counts {
+		df, err := docFrequency(reader, term)
+		if err != nil {
+			return nil, err
+		}
+		if df < 2 {
+			continue
+		}
+		if total >= mltMinCorpus && float64(df) > mltMaxDocShare*float64(total) {
+			continue
+		}
+
+		idf := math.Log(float64(total+1)/float64(df+1)) + 1
+		terms = append(terms, weightedTerm{text: term, weight: (1 + math.Log(count)) * idf})
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight != terms[j].weight {
+			return terms[i].weight > terms[j].weight
+		}
+		return terms[i].text < terms[j].text
+	})
+	if len(terms) > mltMaxTerms {
+		terms = terms[:mltMaxTerms]
+	}
+	return terms, nil
+}
+
+// docFrequency counts the notes containing term in any of the mltFields
+func docFrequency(reader index.IndexReader, term string) (uint64, error) {
+	var df uint64
+	for _, field := range mltFields {
+		tfr, err := reader.TermFieldReader(context.Background(), []byte(term), field, false, false, false)
+		if err != nil {
+			return 0, err
+		}
+		df = max(df, tfr.Count())
+		tfr.Close()
+	}
+	return df, nil
+}
+
+// mltCandidate rules out terms too short or too numeric to describe a note
+func mltCandidate(term string) bool {
+	if utf8.RuneCountInString(term) < mltMinTermLength {
+		return false
+	}
+	for _, r := range term {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"zendown/database"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Clause kinds
+const (
+	ClauseTerm       = "term"
+	ClausePhrase     = "phrase"
+	ClauseDate       = "date"
+	ClauseCollection = "collection"
+)
+
+// Occur says how a clause affects matching
+type Occur int
+
+const (
+	// Should clauses raise the score; at least one must match unless the
+	// query has required text clauses
+	Should Occur = iota
+	// Must clauses are required (+term, and every filter)
+	Must
+	// MustNot clauses exclude matching notes (-term)
+	MustNot
+)
+
+// ParseError reports why and where a query could not be parsed. Pos is the
+// 1-based character position in the query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Clause is one element of a parsed query
+type Clause struct {
+	Kind  string
+	Occur Occur
+	// Field is one of the text fields (title, content, callout, code or
+	// latex) for text clauses, empty for all of them, and created_at or
+	// updated_at for date clauses
+	Field string
+	// Value is the text, phrase or collection name
+	Value string
+	// From (inclusive) and To (exclusive) bound date clauses; zero is open
+	From time.Time
+	To   time.Time
+	// Pos is the 1-based position of the clause in the query
+	Pos int
+
+	noteIDs []int64
+}
+
+// Query is a parsed search query. The syntax is a list of clauses:
+//
+//	word             match in any text field
+//	"exact phrase"   phrase match in any text field
+//	title:word       match in one field: title, content (the prose), callout,
+//	                 code (code blocks) or latex (equations); title:"a phrase"
+//	+clause          the clause is required
+//	-clause          notes matching the clause are excluded
+//	created:DATE     filter by creation date, updated:DATE by modification
+//	                 date. DATE is YYYY-MM-DD or a month YYYY-MM, optionally
+//	                 prefixed with >, >=, < or <=, or a range DATE..DATE
+//	collection:name  only notes in the collection; collection:"two words"
+//
+// Other words followed by a colon, such as "TODO: fix" or a URL, are plain
+// words.
+//
+// Plain words and phrases rank results; at least one has to match unless a
+// +clause is present. Filters always apply.
+type Query struct {
+	Clauses []Clause
+}
+
+// ParseQuery parses a query in the syntax described on Query
+func ParseQuery(input string) (*Query, error) {
+	p := &queryParser{input: []rune(input)}
+	q := &Query{}
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+
+		clause, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		q.Clauses = append(q.Clauses, clause)
+	}
+
+	if len(q.Clauses) == 0 {
+		return nil, &ParseError{Pos: 1, Msg: "query is empty"}
+	}
+	return q, nil
+}
+
+type queryParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ParseError{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) clause() (Clause, error) {
+	start := p.pos
+	clause := Clause{Pos: start + 1, Occur: Should}
+
+	switch p.input[p.pos] {
+	case '+':
+		clause.Occur = Must
+		p.pos++
+	case '-':
+		clause.Occur = MustNot
+		p.pos++
+	}
+	if clause.Occur != Should && (p.done() || unicode.IsSpace(p.input[p.pos])) {
+		return clause, p.errorf(start, "expected a term after %q", string(p.input[start]))
+	}
+
+	if p.input[p.pos] == '"' {
+		phrase, err := p.phrase()
+		if err != nil {
+			return clause, err
+		}
+		clause.Kind = ClausePhrase
+		clause.Value = phrase
+		return clause, nil
+	}
+
+	// A field prefix is a known field name followed by a colon and a value.
+	// Anything else, like "TODO: fix" or a URL, is searched as text.
+	fieldStart := p.pos
+	end := p.pos
+	for end < len(p.input) && unicode.IsLetter(p.input[end]) {
+		end++
+	}
+	if end > fieldStart && end+1 < len(p.input) && p.input[end] == ':' && !unicode.IsSpace(p.input[end+1]) {
+		field := strings.ToLower(string(p.input[fieldStart:end]))
+		if slices.Contains(queryFields, field) {
+			p.pos = end + 1
+			return p.fieldClause(clause, field, fieldStart)
+		}
+	}
+
+	clause.Kind = ClauseTerm
+	clause.Value = p.word()
+	return clause, nil
+}
+
+// queryFields are the field prefixes a query can use
+var queryFields = []string{"title", "content", "callout", "code", "latex", "created", "updated", "collection"}
+
+func (p *queryParser) fieldClause(clause Clause, field string, fieldPos int) (Clause, error) {
+	valuePos := p.pos
+	var value string
+	quoted := false
+	if !p.done() && p.input[p.pos] == '"' {
+		phrase, err := p.phrase()
+		if err != nil {
+			return clause, err
+		}
+		value = phrase
+		quoted = true
+	} else {
+		value = p.word()
+	}
+	if value == "" {
+		return clause, p.errorf(valuePos, "missing value for %s:", field)
+	}
+
+	switch field {
+	case "title", "content", "callout", "code", "latex":
+		clause.Kind = ClauseTerm
+		if quoted {
+			clause.Kind = ClausePhrase
+		}
+		clause.Field = field
+		clause.Value = value
+	case "created", "updated":
+		if clause.Occur == Should {
+			clause.Occur = Must
+		}
+		from, to, err := parseDateFilter(value)
+		if err != nil {
+			return clause, p.errorf(valuePos, "%v", err)
+		}
+		clause.Kind = ClauseDate
+		clause.Field = field + "_at"
+		clause.Value = value
+		clause.From = from
+		clause.To = to
+	case "collection":
+		if clause.Occur == Should {
+			clause.Occur = Must
+		}
+		clause.Kind = ClauseCollection
+		clause.Value = value
+	default:
+		return clause, p.errorf(fieldPos, "unknown field %q", field)
+	}
+
+	return clause, nil
+}
+
+// phrase reads a double-quoted phrase starting at the current position
+func (p *queryParser) phrase() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+
+	end := p.pos
+	for end < len(p.input) && p.input[end] != '"' {
+		end++
+	}
+	if end == len(p.input) {
+		return "", p.errorf(start, "unterminated phrase")
+	}
+
+	phrase := strings.TrimSpace(string(p.input[p.pos:end]))
+	p.pos = end + 1
+	if phrase == "" {
+		return "", p.errorf(start, "empty phrase")
+	}
+	return phrase, nil
+}
+
+// word reads up to the next space
+func (p *queryParser) word() string {
+	start := p.pos
+	for !p.done() && !unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// Date filters take a day or, to drill down into a month facet, a month
+const (
+	dateLayout  = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// parseDateFilter turns a date filter value into a half-open UTC range
+func parseDateFilter(value string) (time.Time, time.Time, error) {
+	// parsePeriod returns the start of a day or month and the start of the
+	// next one
+	parsePeriod := func(s string) (time.Time, time.Time, error) {
+		if t, err := time.Parse(dateLayout, s); err == nil {
+			return t, t.AddDate(0, 0, 1), nil
+		}
+		if t, err := time.Parse(monthLayout, s); err == nil {
+			return t, t.AddDate(0, 1, 0), nil
+		}
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or YYYY-MM", s)
+	}
+
+	if from, to, ok := strings.Cut(value, ".."); ok {
+		start, _, err := parsePeriod(from)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		endStart, end, err := parsePeriod(to)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if endStart.Before(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("date range %q ends before it starts", value)
+		}
+		return start, end, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		rest, ok := strings.CutPrefix(value, op)
+		if !ok {
+			continue
+		}
+		start, next, err := parsePeriod(rest)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		switch op {
+		case ">=":
+			return start, time.Time{}, nil
+		case ">":
+			return next, time.Time{}, nil
+		case "<=":
+			return time.Time{}, next, nil
+		default:
+			return time.Time{}, start, nil
+		}
+	}
+
+	return parsePeriod(value)
+}
+
+// ResolveCollections looks up the notes of every collection filter. It must
+// be called before the query is run or used as a filter.
+func (q *Query) ResolveCollections(noteIDs func(name string) ([]int64, error)) error {
+	for i := range q.Clauses {
+		clause := &q.Clauses[i]
+		if clause.Kind != ClauseCollection {
+			continue
+		}
+
+		ids, err := noteIDs(clause.Value)
+		if err != nil {
+			return err
+		}
+		clause.noteIDs = ids
+	}
+	return nil
+}
+
+// Text returns the words and phrases the query looks for, without fields,
+// operators or filters, for backends that only take free text
+func (q *Query) Text() string {
+	var parts []string
+	for _, clause := range q.Clauses {
+		if clause.Occur == MustNot {
+			continue
+		}
+		if clause.Kind == ClauseTerm || clause.Kind == ClausePhrase {
+			parts = append(parts, clause.Value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Filter reports whether a note passes the query's date and collection
+// filters. Text clauses are not checked.
+func (q *Query) Filter(note *database.Note) bool {
+	for _, clause := range q.Clauses {
+		var matches bool
+		switch clause.Kind {
+		case ClauseDate:
+			t := note.UpdatedAt
+			if clause.Field == "created_at" {
+				t = note.CreatedAt
+			}
+			matches = (clause.From.IsZero() || !t.Before(clause.From)) && (clause.To.IsZero() || t.Before(clause.To))
+		case ClauseCollection:
+			for _, id := range clause.noteIDs {
+				if id == note.ID {
+					matches = true
+					break
+				}
+			}
+		default:
+			continue
+		}
+
+		if matches == (clause.Occur == MustNot) {
+			return false
+		}
+	}
+	return true
+}
+
+// bleveQuery compiles the query for the note index
+func (q *Query) bleveQuery(languages []string) query.Query {
+	boolean := bleve.NewBooleanQuery()
+	hasShould, hasTextMust := false, false
+
+	for _, clause := range q.Clauses {
+		var compiled query.Query
+		switch clause.Kind {
+		case ClauseTerm, ClausePhrase:
+			compiled = textQuery(clause, languages)
+			if clause.Occur == Should {
+				hasShould = true
+			}
+			if clause.Occur == Must {
+				hasTextMust = true
+			}
+		case ClauseDate:
+			inclusive, exclusive := true, false
+			dateQuery := bleve.NewDateRangeInclusiveQuery(clause.From, clause.To, &inclusive, &exclusive)
+			dateQuery.SetField(clause.Field)
+			compiled = dateQuery
+		case ClauseCollection:
+			ids := make([]string, 0, len(clause.noteIDs))
+			for _, id := range clause.noteIDs {
+				ids = append(ids, strconv.FormatInt(id, 10))
+			}
+			compiled = bleve.NewDocIDQuery(ids)
+		}
+
+		switch clause.Occur {
+		case Must:
+			boolean.AddMust(compiled)
+		case MustNot:
+			boolean.AddMustNot(compiled)
+		default:
+			boolean.AddShould(compiled)
+		}
+	}
+
+	if hasShould && !hasTextMust {
+		boolean.SetMinShould(1)
+	}
+	if boolean.Must == nil && boolean.Should == nil {
+		// Only exclusions or filters: start from every note
+		boolean.AddMust(bleve.NewMatchAllQuery())
+	}
+
+	return boolean
+}
+
+// textQuery matches a word or phrase in one field, or in every text field
+// with title matches boosted. Fields with language-specific copies are
+// searched in all of them.
+func textQuery(clause Clause, languages []string) query.Query {
+	fieldQuery := func(field string) query.Query {
+		if clause.Kind == ClausePhrase {
+			q := bleve.NewMatchPhraseQuery(clause.Value)
+			q.SetField(field)
+			return q
+		}
+		q := bleve.NewMatchQuery(clause.Value)
+		q.SetField(field)
+		return q
+	}
+
+	fields := textFields
+	if clause.Field != "" {
+		fields = []string{clause.Field}
+	}
+
+	// Search the language-specific copies too, each analyzed like the notes
+	// in that language
+	var searched []string
+	for _, field := range fields {
+		searched = append(searched, field)
+		if slices.Contains(localizedFields, field) {
+			for _, lang := range languages {
+				searched = append(searched, localizedField(lang, field))
+			}
+		}
+	}
+	if len(searched) == 1 {
+		return fieldQuery(searched[0])
+	}
+
+	disjunction := bleve.NewDisjunctionQuery()
+	for _, field := range searched {
+		q := fieldQuery(field)
+		if clause.Field == "" && baseField(field) == "title" {
+			q.(query.BoostableQuery).SetBoost(2.0) // Give title matches higher weight
+		}
+		disjunction.AddQuery(q)
+	}
+	return disjunction
+}
+
+// Constraints returns a query holding only the required and excluded text
+// clauses, which a note has to pass whatever ranked it, or nil if there are
+// none
+func (q *Query) Constraints() *Query {
+	var constraints Query
+	for _, clause := range q.Clauses {
+		if (clause.Kind == ClauseTerm || clause.Kind == ClausePhrase) && clause.Occur != Should {
+			constraints.Clauses = append(constraints.Clauses, clause)
+		}
+	}
+	if len(constraints.Clauses) == 0 {
+		return nil
+	}
+	return &constraints
+}
+
+// FTS5Match translates the text clauses into an SQLite FTS5 expression for
+// the notes_fts table, for when the Bleve index cannot answer. Title clauses
+// search the title column and every other field the body. Optional words only
+// narrow the results when no word is required, as they cannot be boosted. It
+// returns an empty string when no text has to match.
+func (q *Query) FTS5Match() string {
+	var must, should, mustNot []string
+	for _, clause := range q.Clauses {
+		if clause.Kind != ClauseTerm && clause.Kind != ClausePhrase {
+			continue
+		}
+
+		expr := ftsClause(clause)
+		switch clause.Occur {
+		case Must:
+			must = append(must, expr)
+		case MustNot:
+			mustNot = append(mustNot, expr)
+		default:
+			should = append(should, expr)
+		}
+	}
+
+	if len(must) == 0 && len(should) > 0 {
+		must = append(must, "("+strings.Join(should, " OR ")+")")
+	}
+	if len(must) == 0 {
+		return ""
+	}
+
+	match := strings.Join(must, " AND ")
+	for _, expr := range mustNot {
+		match += " NOT " + expr
+	}
+	return match
+}
+
+// FTS5Excluded returns an FTS5 expression matching the notes excluded by
+// -clauses, or an empty string if nothing is excluded. FTS5 cannot negate on
+// its own, so queries without required text exclude these notes themselves.
+func (q *Query) FTS5Excluded() string {
+	var excluded []string
+	for _, clause := range q.Clauses {
+		if (clause.Kind == ClauseTerm || clause.Kind == ClausePhrase) && clause.Occur == MustNot {
+			excluded = append(excluded, ftsClause(clause))
+		}
+	}
+	return strings.Join(excluded, " OR ")
+}
+
+// ftsClause translates a text clause into an FTS5 expression
+func ftsClause(clause Clause) string {
+	expr := database.FTSQuote(clause.Value)
+	switch clause.Field {
+	case "":
+		return expr
+	case "title":
+		return "title : " + expr
+	default:
+		return "body : " + expr
+	}
+}
+
+// HasFilters reports whether the query has date or collection filters
+func (q *Query) HasFilters() bool {
+	for _, clause := range q.Clauses {
+		if clause.Kind == ClauseDate || clause.Kind == ClauseCollection {
+			return true
+		}
+	}
+	return false
+}
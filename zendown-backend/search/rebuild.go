@@ -0,0 +1,279 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"zendown/database"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// Rebuild states
+const (
+	RebuildIdle     = "idle"
+	RebuildBuilding = "building"
+	RebuildSwapping = "swapping"
+	RebuildDone     = "done"
+	RebuildFailed   = "failed"
+)
+
+// ErrRebuildInProgress is returned when a rebuild is requested while one runs
+var ErrRebuildInProgress = errors.New("an index rebuild is already in progress")
+
+// rebuildBatchSize is the number of notes written to the new index at once
+const rebuildBatchSize = 100
+
+// Side directories next to the serving index. A rebuild writes to nextSuffix
+// and the serving index is moved to oldSuffix while the two are swapped.
+const (
+	nextSuffix = ".next"
+	oldSuffix  = ".old"
+)
+
+// IndexedNote is a note as written to the index by a rebuild
+type IndexedNote struct {
+	Note        *database.Note
+	Collections []string
+}
+
+// RebuildStatus reports the progress of the latest index rebuild
+type RebuildStatus struct {
+	State      string     `json:"state"`
+	Reason     string     `json:"reason,omitempty"`
+	Total      int        `json:"total"`
+	Indexed    int        `json:"indexed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// MappingVersion is the version a rebuild produces; ServingVersion is the
+	// version of the index answering queries, or 0 if none is
+	MappingVersion int `json:"mapping_version"`
+	ServingVersion int `json:"serving_version"`
+}
+
+type rebuildState struct {
+	mu     sync.Mutex
+	status RebuildStatus
+}
+
+// RebuildStatus returns the progress of the running or latest rebuild
+func (s *BM25SearchService) RebuildStatus() RebuildStatus {
+	s.rebuild.mu.Lock()
+	status := s.rebuild.status
+	s.rebuild.mu.Unlock()
+
+	if status.State == "" {
+		status.State = RebuildIdle
+	}
+	status.MappingVersion = MappingVersion
+
+	s.mu.RLock()
+	if s.index != nil {
+		status.ServingVersion = s.servingVersion
+	}
+	s.mu.RUnlock()
+
+	return status
+}
+
+// StartRebuild builds a fresh index from notes in a side directory while the
+// current index keeps serving, then swaps the two. Changes made to notes
+// during the build only reach the old index, so the caller should reconcile
+// once done reports success. done is called when the rebuild finishes.
+func (s *BM25SearchService) StartRebuild(reason string, notes func() ([]IndexedNote, error), done func(error)) error {
+	s.rebuild.mu.Lock()
+	defer s.rebuild.mu.Unlock()
+
+	if state := s.rebuild.status.State; state == RebuildBuilding || state == RebuildSwapping {
+		return ErrRebuildInProgress
+	}
+
+	now := time.Now()
+	s.rebuild.status = RebuildStatus{
+		State:     RebuildBuilding,
+		Reason:    reason,
+		StartedAt: &now,
+	}
+
+	go func() {
+		err := s.runRebuild(notes)
+
+		finished := time.Now()
+		s.rebuild.mu.Lock()
+		s.rebuild.status.FinishedAt = &finished
+		if err != nil {
+			s.rebuild.status.State = RebuildFailed
+			s.rebuild.status.Error = err.Error()
+		} else {
+			s.rebuild.status.State = RebuildDone
+		}
+		s.rebuild.mu.Unlock()
+
+		if done != nil {
+			done(err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *BM25SearchService) runRebuild(notes func() ([]IndexedNote, error)) error {
+	nextPath := s.path + nextSuffix
+	if err := os.RemoveAll(nextPath); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", nextPath, err)
+	}
+
+	all, err := notes()
+	if err != nil {
+		return fmt.Errorf("failed to load notes: %w", err)
+	}
+	s.setRebuildProgress(func(status *RebuildStatus) { status.Total = len(all) })
+
+	next, err := createIndex(nextPath, s.languages)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
+	for start := 0; start < len(all); start += rebuildBatchSize {
+		end := min(start+rebuildBatchSize, len(all))
+
+		batch := next.NewBatch()
+		for _, note := range all[start:end] {
+			doc := s.searchDocument(note.Note, note.Collections)
+			if err := batch.Index(doc.ID, doc); err != nil {
+				next.Close()
+				os.RemoveAll(nextPath)
+				return fmt.Errorf("failed to index note %d: %w", note.Note.ID, err)
+			}
+		}
+		if err := next.Batch(batch); err != nil {
+			next.Close()
+			os.RemoveAll(nextPath)
+			return fmt.Errorf("failed to write batch: %w", err)
+		}
+
+		s.setRebuildProgress(func(status *RebuildStatus) { status.Indexed = end })
+	}
+
+	// The index has to be closed before its directory can be moved
+	if err := next.Close(); err != nil {
+		os.RemoveAll(nextPath)
+		return fmt.Errorf("failed to close new index: %w", err)
+	}
+
+	s.setRebuildProgress(func(status *RebuildStatus) { status.State = RebuildSwapping })
+	return s.swap(nextPath)
+}
+
+// swap replaces the serving index with the one at nextPath. Queries wait for
+// the swap instead of failing; if it cannot complete the old index is put
+// back.
+func (s *BM25SearchService) swap(nextPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldPath := s.path + oldSuffix
+	if err := os.RemoveAll(oldPath); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", oldPath, err)
+	}
+
+	if s.index != nil {
+		if err := s.index.Close(); err != nil {
+			log.Printf("Failed to close BM25 index before swap: %v", err)
+		}
+		s.index = nil
+	}
+
+	// A damaged index may have left nothing behind to move aside
+	hadOld := true
+	if err := os.Rename(s.path, oldPath); err != nil {
+		if !os.IsNotExist(err) {
+			s.reopen(s.path)
+			return fmt.Errorf("failed to move old index aside: %w", err)
+		}
+		hadOld = false
+	}
+
+	if err := os.Rename(nextPath, s.path); err != nil {
+		if hadOld {
+			if err := os.Rename(oldPath, s.path); err != nil {
+				log.Printf("Failed to restore old BM25 index: %v", err)
+			}
+		}
+		s.reopen(s.path)
+		return fmt.Errorf("failed to move new index into place: %w", err)
+	}
+
+	index, err := bleve.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open new index: %w", err)
+	}
+	s.index = index
+	s.servingVersion = MappingVersion
+	s.rebuildReason = ""
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		log.Printf("Failed to remove old BM25 index: %v", err)
+	}
+
+	return nil
+}
+
+// reopen tries to serve the index at path again after a failed swap. The
+// caller holds s.mu.
+func (s *BM25SearchService) reopen(path string) {
+	index, err := bleve.Open(path)
+	if err != nil {
+		log.Printf("Failed to reopen BM25 index after failed swap: %v", err)
+		return
+	}
+	s.index = index
+}
+
+func (s *BM25SearchService) setRebuildProgress(update func(status *RebuildStatus)) {
+	s.rebuild.mu.Lock()
+	update(&s.rebuild.status)
+	s.rebuild.mu.Unlock()
+}
+
+// recoverInterruptedSwap finishes or rolls back a swap that was cut short,
+// for example by a crash, and removes leftover side directories. If the
+// serving index is missing, a complete new index is preferred over the old
+// one.
+func recoverInterruptedSwap(path string) error {
+	nextPath, oldPath := path+nextSuffix, path+oldSuffix
+
+	if !exists(path) {
+		switch {
+		case exists(nextPath) && exists(oldPath):
+			// The old index was moved aside, so the new one was complete
+			log.Printf("Completing interrupted BM25 index swap")
+			if err := os.Rename(nextPath, path); err != nil {
+				return err
+			}
+		case exists(oldPath):
+			log.Printf("Restoring BM25 index from interrupted swap")
+			if err := os.Rename(oldPath, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, leftover := range []string{nextPath, oldPath} {
+		if err := os.RemoveAll(leftover); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
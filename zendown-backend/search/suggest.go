@@ -0,0 +1,249 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	bleveunicode "github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// suggestAnalyzer indexes every prefix of every title word, so a prefix
+// lookup is a single term query
+const suggestAnalyzer = "title_suggest"
+
+// maxSuggestPrefix is the longest prefix indexed for suggestions. Longer
+// words typed by the user are cut to it.
+const maxSuggestPrefix = 20
+
+// Suggestions mix the relevance of a title with how recently the note was
+// edited. A note edited recencyHalfLife ago gets half the recency boost.
+const (
+	suggestRecencyWeight = 0.3
+	recencyHalfLife      = 30 * 24 * time.Hour
+)
+
+// TitleSuggestion is a note whose title completes the typed text
+type TitleSuggestion struct {
+	NoteID    int64     `json:"note_id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Score     float64   `json:"score"`
+}
+
+// TermSuggestion is an indexed word close to a typed word, for "did you
+// mean" hints
+type TermSuggestion struct {
+	Term     string `json:"term"`
+	Distance int    `json:"distance"`
+}
+
+// Suggestions are the completions for search-as-you-type
+type Suggestions struct {
+	Titles []TitleSuggestion `json:"titles"`
+	Terms  []TermSuggestion  `json:"terms"`
+}
+
+func addSuggestAnalyzer(indexMapping *mapping.IndexMappingImpl) error {
+	err := indexMapping.AddCustomTokenFilter("title_edge_ngram", map[string]interface{}{
+		"type": edgengram.Name,
+		"min":  1.0,
+		"max":  float64(maxSuggestPrefix),
+	})
+	if err != nil {
+		return err
+	}
+
+	return indexMapping.AddCustomAnalyzer(suggestAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     bleveunicode.Name,
+		"token_filters": []string{lowercase.Name, "title_edge_ngram"},
+	})
+}
+
+// Suggest completes text typed into the quick switcher. Every typed word must
+// start a title word or be within a small edit distance of one; the typo
+// tolerance grows with the word length. Titles are ranked by relevance and
+// recency.
+func (s *BM25SearchService) Suggest(text string, limit int) (*Suggestions, error) {
+	suggestions := &Suggestions{Titles: []TitleSuggestion{}, Terms: []TermSuggestion{}}
+
+	words := suggestWords(text)
+	if len(words) == 0 {
+		return suggestions, nil
+	}
+
+	conjunction := bleve.NewConjunctionQuery()
+	for _, word := range words {
+		conjunction.AddQuery(suggestWordQuery(word))
+	}
+
+	// Fetch extra candidates so recency can promote some of them
+	searchRequest := bleve.NewSearchRequest(conjunction)
+	searchRequest.Size = limit * 3
+	searchRequest.Fields = []string{"title", "updated_at"}
+	searchRequest.IncludeLocations = true
+
+	var searchResult *bleve.SearchResult
+	err := s.withIndex(func(index bleve.Index) error {
+		var err error
+		searchResult, err = index.Search(searchRequest)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	maxScore := searchResult.MaxScore
+	for _, hit := range searchResult.Hits {
+		noteID, err := parseNoteID(hit.ID)
+		if err != nil {
+			continue
+		}
+
+		updatedAt := getTimeField(hit.Fields, "updated_at")
+		relevance := 0.0
+		if maxScore > 0 {
+			relevance = hit.Score / maxScore
+		}
+		recency := math.Pow(0.5, now.Sub(updatedAt).Hours()/recencyHalfLife.Hours())
+		if recency > 1 {
+			recency = 1
+		}
+
+		suggestions.Titles = append(suggestions.Titles, TitleSuggestion{
+			NoteID:    noteID,
+			Title:     getStringField(hit.Fields, "title"),
+			UpdatedAt: updatedAt,
+			Score:     (1-suggestRecencyWeight)*relevance + suggestRecencyWeight*recency,
+		})
+	}
+
+	sort.SliceStable(suggestions.Titles, func(i, j int) bool {
+		return suggestions.Titles[i].Score > suggestions.Titles[j].Score
+	})
+	if len(suggestions.Titles) > limit {
+		suggestions.Titles = suggestions.Titles[:limit]
+	}
+
+	suggestions.Terms = termSuggestions(words, searchResult, limit)
+	return suggestions, nil
+}
+
+// suggestWords splits typed text into lowercase words
+func suggestWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// suggestWordQuery matches titles with a word that starts with word, or,
+// boosted less, a word within the typo tolerance of it
+func suggestWordQuery(word string) query.Query {
+	prefix := word
+	if runes := []rune(word); len(runes) > maxSuggestPrefix {
+		prefix = string(runes[:maxSuggestPrefix])
+	}
+	prefixQuery := bleve.NewTermQuery(prefix)
+	prefixQuery.SetField("title_suggest")
+	prefixQuery.SetBoost(2.0)
+
+	fuzziness := suggestFuzziness(word)
+	if fuzziness == 0 {
+		return prefixQuery
+	}
+
+	fuzzyQuery := bleve.NewFuzzyQuery(word)
+	fuzzyQuery.SetField("title")
+	fuzzyQuery.SetFuzziness(fuzziness)
+
+	return bleve.NewDisjunctionQuery(prefixQuery, fuzzyQuery)
+}
+
+// suggestFuzziness allows one typo from three letters on and two from six,
+// so short words do not match nearly everything
+func suggestFuzziness(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 6:
+		return 2
+	case n >= 3:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// termSuggestions collects the title words that matched a typed word only
+// through typo tolerance, closest first
+func termSuggestions(words []string, result *bleve.SearchResult, limit int) []TermSuggestion {
+	typed := make(map[string]bool, len(words))
+	for _, word := range words {
+		typed[word] = true
+	}
+
+	best := make(map[string]int)
+	for _, hit := range result.Hits {
+		for term := range hit.Locations["title"] {
+			if typed[term] {
+				continue
+			}
+			for _, word := range words {
+				distance := editDistance(word, term)
+				if distance == 0 || distance > suggestFuzziness(word) {
+					continue
+				}
+				if d, ok := best[term]; !ok || distance < d {
+					best[term] = distance
+				}
+			}
+		}
+	}
+
+	terms := make([]TermSuggestion, 0, len(best))
+	for term, distance := range best {
+		terms = append(terms, TermSuggestion{Term: term, Distance: distance})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Distance != terms[j].Distance {
+			return terms[i].Distance < terms[j].Distance
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > limit {
+		terms = terms[:limit]
+	}
+	return terms
+}
+
+// editDistance is the Levenshtein distance between a and b in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
@@ -0,0 +1,119 @@
+package semware
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// BatchOptions control UpsertDocuments. SemWare has no batch endpoint, so
+// every document is still sent in its own request.
+type BatchOptions struct {
+	// ProgressEvery is the number of documents a worker sends one after the
+	// other before progress is reported
+	ProgressEvery int
+	// Concurrency is the number of workers, and so of requests in flight
+	Concurrency int
+	// Progress, if set, is called after each worker's run of ProgressEvery
+	// documents. Calls never overlap.
+	Progress func(BatchProgress)
+}
+
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		ProgressEvery: 20,
+		Concurrency:   4,
+	}
+}
+
+// BatchProgress counts the documents of a batch handled so far
+type BatchProgress struct {
+	Total     int `json:"total"`
+	Upserted  int `json:"upserted"`
+	Failed    int `json:"failed"`
+	Remaining int `json:"remaining"`
+}
+
+// BatchError is a document of a batch that could not be upserted
+type BatchError struct {
+	ID  string `json:"id"`
+	Err string `json:"error"`
+}
+
+// UpsertDocuments upserts documents with up to Concurrency requests in
+// flight. SemWare takes one document per upsert request, so each document
+// goes through UpsertDocument and gets the same timeouts and retries.
+// Documents that fail are returned; the batch stops early, returning the
+// cause, when ctx ends or SemWare becomes unavailable.
+func (c *Client) UpsertDocuments(ctx context.Context, docs []UpsertRequest, opts BatchOptions) ([]BatchError, error) {
+	if opts.ProgressEvery <= 0 {
+		opts.ProgressEvery = DefaultBatchOptions().ProgressEvery
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultBatchOptions().Concurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runs := make(chan []UpsertRequest)
+	go func() {
+		defer close(runs)
+		for start := 0; start < len(docs); start += opts.ProgressEvery {
+			select {
+			case runs <- docs[start:min(start+opts.ProgressEvery, len(docs))]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	progress := BatchProgress{Total: len(docs), Remaining: len(docs)}
+	var failures []BatchError
+	var abort error
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range runs {
+				var runFailures []BatchError
+				var runAbort error
+				handled := 0
+				for _, doc := range run {
+					if ctx.Err() != nil {
+						break
+					}
+					_, err := c.UpsertDocument(ctx, doc.ID, doc.Content)
+					if err != nil && (errors.Is(err, ErrUnavailable) || ctx.Err() != nil) {
+						runAbort = err
+						break
+					}
+					if err != nil {
+						runFailures = append(runFailures, BatchError{ID: doc.ID, Err: err.Error()})
+					}
+					handled++
+				}
+
+				mu.Lock()
+				if runAbort != nil && abort == nil {
+					abort = runAbort
+					cancel()
+				}
+				failures = append(failures, runFailures...)
+				progress.Failed += len(runFailures)
+				progress.Upserted += handled - len(runFailures)
+				progress.Remaining -= handled
+				if opts.Progress != nil && handled > 0 {
+					opts.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failures, abort
+}
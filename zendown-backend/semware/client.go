@@ -2,18 +2,27 @@ package semware
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
+// Client calls the SemWare API. Every call runs under the caller's context
+// and a per-attempt timeout, failed attempts are retried with jittered
+// backoff, and a circuit breaker fails calls fast while SemWare is down.
+// Failures caused by SemWare being unavailable match ErrUnavailable.
 type Client struct {
 	baseURL string
 	apiKey  string
 	client  *http.Client
+	opts    Options
+	breaker *breaker
 }
 
 type UpsertRequest struct {
@@ -34,11 +43,26 @@ type SimilarRequest struct {
 	Threshold      float64 `json:"threshold,omitempty"`
 	TopK           int     `json:"top_k,omitempty"`
 	DistanceMetric string  `json:"distance_metric,omitempty"`
+	IncludeChunks  bool    `json:"include_chunks,omitempty"`
 }
 
 type SimilarResult struct {
 	ID    string  `json:"id"`
 	Score float64 `json:"score"`
+	// Chunks are the passages of the document that matched, if SemWare
+	// reports them
+	Chunks []ChunkResult `json:"chunks,omitempty"`
+}
+
+// ChunkResult is a matching chunk of a document. Offsets count characters
+// of the upserted content; SemWare versions that do not report them leave
+// EndOffset at 0.
+type ChunkResult struct {
+	Index       int     `json:"chunk_index"`
+	Text        string  `json:"text"`
+	StartOffset int     `json:"start_offset"`
+	EndOffset   int     `json:"end_offset"`
+	Score       float64 `json:"score"`
 }
 
 type SimilarResponse struct {
@@ -52,6 +76,7 @@ type SemanticRequest struct {
 	Threshold      float64 `json:"threshold,omitempty"`
 	TopK           int     `json:"top_k,omitempty"`
 	DistanceMetric string  `json:"distance_metric,omitempty"`
+	IncludeChunks  bool    `json:"include_chunks,omitempty"`
 }
 
 type SemanticResponse struct {
@@ -60,164 +85,289 @@ type SemanticResponse struct {
 	Count          int             `json:"count"`
 }
 
+// placeholderAPIKey is the example key from the setup instructions. It used
+// to be the default, so it is still found in configurations, but it is never
+// a real key.
+const placeholderAPIKey = "your-secure-api-key-here"
+
+// errNoAPIKey is the cause of calls refused because no usable API key is set
+var errNoAPIKey = errors.New("SEMWARE_API_KEY is not set")
+
 func NewClient() *Client {
 	baseURL := os.Getenv("SEMWARE_URL")
 	if baseURL == "" {
 		baseURL = "http://localhost:8000"
 	}
 
+	// Without a key SemWare is not called; the local backend takes over in
+	// auto mode
 	apiKey := os.Getenv("SEMWARE_API_KEY")
-	if apiKey == "" {
-		apiKey = "your-secure-api-key-here" // Default fallback
+	switch apiKey {
+	case "":
+		log.Printf("Warning: SEMWARE_API_KEY is not set; SemWare will not be used")
+	case placeholderAPIKey:
+		log.Printf("Warning: SEMWARE_API_KEY is the placeholder %q; set the key configured in SemWare. SemWare will not be used", placeholderAPIKey)
+		apiKey = ""
+	}
+
+	opts := DefaultOptions()
+	if timeout := os.Getenv("SEMWARE_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			log.Printf("Ignoring invalid SEMWARE_TIMEOUT %q", timeout)
+		} else {
+			opts.Timeout = d
+		}
 	}
 
+	return NewClientWithOptions(baseURL, apiKey, opts)
+}
+
+func NewClientWithOptions(baseURL, apiKey string, opts Options) *Client {
 	return &Client{
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		client:  &http.Client{},
+		opts:    opts,
+		breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
 	}
 }
 
-func (c *Client) UpsertDocument(id string, content string) (*UpsertResponse, error) {
-	reqBody := UpsertRequest{
-		ID:      id,
-		Content: content,
+// BaseURL returns the address of SemWare
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Configured reports whether a usable API key is set. Without one every
+// call is refused.
+func (c *Client) Configured() bool {
+	return c.apiKey != ""
+}
+
+// BreakerState returns the state of the circuit breaker: closed, open or
+// half-open
+func (c *Client) BreakerState() string {
+	return c.breaker.currentState()
+}
+
+// call sends a request to SemWare and returns the status code and body of the
+// response. Network errors, timeouts and 5xx or 429 responses are retried;
+// if they persist the call fails with an UnavailableError. Any other
+// response is returned for the caller to interpret.
+func (c *Client) call(ctx context.Context, op, method, path string, reqBody interface{}) (int, []byte, error) {
+	var jsonBody []byte
+	if reqBody != nil {
+		var err error
+		jsonBody, err = json.Marshal(reqBody)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
 	}
 
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	if c.apiKey == "" {
+		return 0, nil, &UnavailableError{Op: op, Err: errNoAPIKey}
+	}
+
+	if !c.breaker.allow() {
+		return 0, nil, &UnavailableError{Op: op, Err: errCircuitOpen}
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/api/documents/upsert", bytes.NewBuffer(jsonBody))
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, retryDelay(c.opts.RetryBackoff, attempt)); err != nil {
+				break
+			}
+			log.Printf("SemWare: retrying %s (attempt %d): %v", op, attempt+1, lastErr)
+		}
+
+		status, body, err := c.attempt(ctx, method, path, jsonBody)
+		if err == nil && !retryableStatus(status) {
+			c.breaker.success()
+			return status, body, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("semware API returned status %d: %s", status, string(body))
+		}
+		lastErr = err
+
+		// The caller gave up, which says nothing about SemWare
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		c.breaker.abandon()
+		return 0, nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+
+	c.breaker.failure()
+	return 0, nil, &UnavailableError{Op: op, Err: lastErr}
+}
+
+// attempt makes one request, bounded by the per-attempt timeout
+func (c *Client) attempt(ctx context.Context, method, path string, jsonBody []byte) (int, []byte, error) {
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	var body io.Reader
+	if jsonBody != nil {
+		body = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	if jsonBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return 0, nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("semware API returned status %d", resp.StatusCode)
+	// Read the whole body before the attempt's deadline is cancelled
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return resp.StatusCode, respBody, nil
+}
+
+// retryableStatus reports whether a status means SemWare could not serve the
+// request right now
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// statusError describes a response SemWare rejected
+func statusError(status int, body []byte) error {
+	if len(body) == 0 {
+		return fmt.Errorf("semware API returned status %d", status)
+	}
+	return fmt.Errorf("semware API returned status %d: %s", status, string(body))
+}
+
+func (c *Client) UpsertDocument(ctx context.Context, id string, content string) (*UpsertResponse, error) {
+	reqBody := UpsertRequest{
+		ID:      id,
+		Content: content,
+	}
+
+	status, body, err := c.call(ctx, "upsert", "POST", "/api/documents/upsert", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
 	}
 
 	var response UpsertResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	return &response, nil
 }
 
-func (c *Client) DeleteDocument(id string) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+"/api/documents/"+id, nil)
+func (c *Client) DeleteDocument(ctx context.Context, id string) error {
+	status, body, err := c.call(ctx, "delete", "DELETE", "/api/documents/"+id, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+	// A missing document is already deleted
+	if status == http.StatusNotFound {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("semware API returned status %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return statusError(status, body)
 	}
 
 	return nil
 }
 
-func (c *Client) GetSimilarDocuments(id string, threshold float64) (*SimilarResponse, error) {
-	reqBody := SimilarRequest{
-		ID:             id,
-		Threshold:      threshold,
-		DistanceMetric: "cosine",
-	}
+// QueryOptions tune a similarity query. TopK caps the number of results and
+// an empty DistanceMetric means cosine; 0 leaves TopK to SemWare's default.
+type QueryOptions struct {
+	Threshold      float64
+	TopK           int
+	DistanceMetric string
+}
 
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+func (o QueryOptions) distanceMetric() string {
+	if o.DistanceMetric == "" {
+		return "cosine"
 	}
+	return o.DistanceMetric
+}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/api/search/similar", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// GetSimilarDocuments returns the documents most similar to the document id
+func (c *Client) GetSimilarDocuments(ctx context.Context, id string, opts QueryOptions) (*SimilarResponse, error) {
+	reqBody := SimilarRequest{
+		ID:             id,
+		Threshold:      opts.Threshold,
+		TopK:           opts.TopK,
+		DistanceMetric: opts.distanceMetric(),
+		IncludeChunks:  true,
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
+	status, body, err := c.call(ctx, "similar", "POST", "/api/search/similar", reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("semware API returned status %d", resp.StatusCode)
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
 	}
 
 	var response SimilarResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	return &response, nil
 }
 
-func (c *Client) SemanticSearch(queryText string, threshold float64) (*SemanticResponse, error) {
-	log.Printf("SemWare: SemanticSearch called with query='%s', threshold=%f", queryText, threshold)
+// SemanticSearch returns the documents most similar to queryText
+func (c *Client) SemanticSearch(ctx context.Context, queryText string, opts QueryOptions) (*SemanticResponse, error) {
+	log.Printf("SemWare: SemanticSearch called with query='%s', threshold=%f, top_k=%d, metric=%s", queryText, opts.Threshold, opts.TopK, opts.distanceMetric())
 	log.Printf("SemWare: Using baseURL=%s", c.baseURL)
 
 	reqBody := SemanticRequest{
 		QueryText:      queryText,
-		Threshold:      threshold,
-		DistanceMetric: "cosine",
+		Threshold:      opts.Threshold,
+		TopK:           opts.TopK,
+		DistanceMetric: opts.distanceMetric(),
+		IncludeChunks:  true,
 	}
 
-	jsonBody, err := json.Marshal(reqBody)
+	status, body, err := c.call(ctx, "search", "POST", "/api/search/semantic", reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		return nil, err
 	}
 
-	log.Printf("SemWare: Request body: %s", string(jsonBody))
-
-	req, err := http.NewRequest("POST", c.baseURL+"/api/search/semantic", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	log.Printf("SemWare: Making request to %s", req.URL.String())
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	log.Printf("SemWare: Response status: %d", resp.StatusCode)
+	log.Printf("SemWare: Response status: %d", status)
 
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body to get more details about the error
-		body, _ := io.ReadAll(resp.Body)
+	if status != http.StatusOK {
 		log.Printf("SemWare: Error response body: %s", string(body))
-		return nil, fmt.Errorf("semware API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError(status, body)
 	}
 
 	var response SemanticResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
@@ -0,0 +1,91 @@
+package semware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Probe outcomes
+const (
+	// StatusOK means SemWare answered and accepted the API key
+	StatusOK = "ok"
+	// StatusUnreachable means SemWare could not be reached or failed
+	StatusUnreachable = "unreachable"
+	// StatusUnauthorized means SemWare rejected the API key
+	StatusUnauthorized = "unauthorized"
+	// StatusUnconfigured means no usable API key is set, so SemWare is not
+	// called at all
+	StatusUnconfigured = "unconfigured"
+)
+
+// probeDocumentID names a document that never exists. Looking it up is the
+// cheapest call that needs a valid API key.
+const probeDocumentID = "zendown-probe"
+
+// ProbeResult is what a probe learned about SemWare
+type ProbeResult struct {
+	Status       string    `json:"status"`
+	Version      string    `json:"version,omitempty"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	LatencyMS    float64   `json:"latency_ms"`
+	CheckedAt    time.Time `json:"checked_at"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// healthResponse is the body of SemWare's health check. Older versions only
+// report a status, so every field is optional.
+type healthResponse struct {
+	Status       string   `json:"status"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Probe checks that SemWare is reachable and accepts the API key, and
+// records its version, capabilities and the latency of the health check.
+// Probes make a single attempt each and bypass the circuit breaker, so they
+// see SemWare as it is rather than as the breaker last saw it.
+func (c *Client) Probe(ctx context.Context) ProbeResult {
+	result := ProbeResult{CheckedAt: time.Now()}
+	if c.apiKey == "" {
+		result.Status = StatusUnconfigured
+		result.Error = errNoAPIKey.Error()
+		return result
+	}
+
+	start := time.Now()
+	status, body, err := c.attempt(ctx, "GET", "/health", nil)
+	result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err == nil && status != http.StatusOK {
+		err = statusError(status, body)
+	}
+	if err != nil {
+		result.Status = StatusUnreachable
+		result.Error = err.Error()
+		return result
+	}
+
+	var health healthResponse
+	if err := json.Unmarshal(body, &health); err == nil {
+		result.Version = health.Version
+		result.Capabilities = health.Capabilities
+	}
+
+	// Any answer but an authentication failure means the key was accepted
+	status, body, err = c.attempt(ctx, "GET", "/api/documents/"+probeDocumentID, nil)
+	switch {
+	case err != nil:
+		result.Status = StatusUnreachable
+		result.Error = err.Error()
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		result.Status = StatusUnauthorized
+		result.Error = statusError(status, body).Error()
+	case retryableStatus(status):
+		result.Status = StatusUnreachable
+		result.Error = statusError(status, body).Error()
+	default:
+		result.Status = StatusOK
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+package semware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable matches every error caused by SemWare being unreachable,
+// timing out, failing or being cut off by the circuit breaker. Callers can
+// test for it with errors.Is and report the semantic features as
+// unavailable rather than broken.
+var ErrUnavailable = errors.New("semantic features unavailable")
+
+// errCircuitOpen is the cause of calls refused by the circuit breaker
+var errCircuitOpen = errors.New("circuit breaker is open after repeated failures")
+
+// UnavailableError is returned when SemWare cannot serve a call. Op names
+// the call and Err the last failure.
+type UnavailableError struct {
+	Op  string
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", ErrUnavailable, e.Op, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+type Options struct {
+	// Timeout bounds each attempt of a call; the caller's context can end it
+	// sooner
+	Timeout time.Duration
+	// MaxRetries is the number of extra attempts after a failure
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry; it doubles per retry
+	// and a random part of it is used so clients do not retry in step
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failed calls that opens
+	// the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long an open breaker refuses calls before it
+	// lets one through to probe SemWare
+	BreakerCooldown time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          10 * time.Second,
+		MaxRetries:       2,
+		RetryBackoff:     200 * time.Millisecond,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breaker stops calls to SemWare after repeated failures so requests fail
+// fast instead of each waiting for a timeout. After the cooldown a single
+// probe call is let through; its outcome closes or reopens the breaker.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow reports whether a call may go ahead
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// Only the probe goes through until it reports back
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon releases a probe whose call ended without telling anything about
+// SemWare, for example because the caller went away
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) currentState() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// retryDelay returns a random delay of up to the backoff for the given retry,
+// starting at 1
+func retryDelay(base time.Duration, retry int) time.Duration {
+	backoff := base << (retry - 1)
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
+
+// sleep waits for d or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
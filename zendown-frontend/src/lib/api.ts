@@ -42,6 +42,16 @@ export interface FullTextSearchResponse {
 	score: number;
 }
 
+// A page of search results; next_cursor fetches the next one and is null on
+// the last page
+export interface SearchPage<T> {
+	results: T[];
+	total: number;
+	offset: number;
+	limit: number;
+	next_cursor: string | null;
+}
+
 export interface Collection {
 	id: number;
 	name: string;
@@ -130,8 +140,14 @@ class API {
 		}
 	}
 
-	async searchNotes(query: string): Promise<Note[]> {
-		const response = await fetch(`${this.baseURL}/notes/search?q=${encodeURIComponent(query)}`);
+	// Pass the next_cursor of a page to get the page after it
+	async searchNotes(query: string, cursor?: string): Promise<SearchPage<Note>> {
+		const params = new URLSearchParams({ q: query });
+		if (cursor) {
+			params.set('cursor', cursor);
+		}
+
+		const response = await fetch(`${this.baseURL}/notes/search?${params}`);
 
 		if (!response.ok) {
 			throw new Error(`Failed to search notes: ${response.statusText}`);
@@ -140,10 +156,12 @@ class API {
 		return response.json();
 	}
 
-	async getRelatedNotes(noteId: number, threshold: number = 0.3): Promise<RelatedNoteResponse[]> {
-		const params = new URLSearchParams({
-			threshold: threshold.toString()
-		});
+	// Without a threshold the server's default from the semantic settings applies
+	async getRelatedNotes(noteId: number, threshold?: number): Promise<RelatedNoteResponse[]> {
+		const params = new URLSearchParams();
+		if (threshold !== undefined) {
+			params.set('threshold', threshold.toString());
+		}
 		
 		const response = await fetch(`${this.baseURL}/notes/${noteId}/related?${params}`);
 
@@ -154,12 +172,15 @@ class API {
 		return response.json();
 	}
 
-	async semanticSearch(query: string, threshold: number = 0.3): Promise<SemanticSearchResponse[]> {
-		const params = new URLSearchParams({
-			q: query,
-			threshold: threshold.toString()
-		});
-		
+	async semanticSearch(query: string, threshold?: number, cursor?: string): Promise<SearchPage<SemanticSearchResponse>> {
+		const params = new URLSearchParams({ q: query });
+		if (threshold !== undefined) {
+			params.set('threshold', threshold.toString());
+		}
+		if (cursor) {
+			params.set('cursor', cursor);
+		}
+
 		const response = await fetch(`${this.baseURL}/notes/semantic-search?${params}`);
 
 		if (!response.ok) {
@@ -169,12 +190,15 @@ class API {
 		return response.json();
 	}
 
-	async fullTextSearch(query: string, limit: number = 20): Promise<FullTextSearchResponse[]> {
+	async fullTextSearch(query: string, limit: number = 20, cursor?: string): Promise<SearchPage<FullTextSearchResponse>> {
 		const params = new URLSearchParams({
 			q: query,
 			limit: limit.toString()
 		});
-		
+		if (cursor) {
+			params.set('cursor', cursor);
+		}
+
 		const response = await fetch(`${this.baseURL}/notes/fulltext-search?${params}`);
 
 		if (!response.ok) {